@@ -40,7 +40,7 @@ func (h *SSEHandler) HandleEvent(event nexmosphere.Event) {
 	}
 
 	sse := sseEvent{
-		Event:   event.Type,
+		Event:   string(event.Type),
 		Message: string(data),
 	}
 
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
@@ -28,40 +29,37 @@ func main() {
 	service.AddHandler(nexmosphere.EventHandlerFunc(func(e nexmosphere.Event) {
 		// Optional: Customize hold interval for specific devices (default is 500ms)
 		// Uncomment below and add "time" import to customize:
-		// if e.Type == "device" && e.Action == "TYPE" {
+		// if d, ok := e.Device(); ok && d.Key == "TYPE" {
 		// 	service.SetDeviceHoldInterval(e.Controller, e.Address, 200*time.Millisecond)
 		// }
 
 		switch e.Type {
-		case "button":
+		case nexmosphere.TypeButton:
 			handleButtonEvent(e)
-		case "rfid-tag":
+		case nexmosphere.TypeRFIDTag:
 			handleRFIDTagEvent(e)
-		case "rfid-antenna":
+		case nexmosphere.TypeRFIDAntenna:
 			handleRFIDAntennaEvent(e)
-		case "presence":
+		case nexmosphere.TypePresence:
 			handlePresenceEvent(e)
-		case "controller":
+		case nexmosphere.TypeController:
 			handleControllerEvent(e)
-		case "device":
+		case nexmosphere.TypeDevice:
 			handleDeviceEvent(e)
 		}
 	}))
 
-	// Start the service
-	if err := service.Start(); err != nil {
-		log.Fatalf("Failed to start service: %s", err)
-	}
-	defer service.Stop()
+	// Run the service until interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	log.Info("Service started. Press Ctrl+C to stop...")
 
-	// Wait for interrupt signal
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	if err := service.Run(ctx); err != nil {
+		log.Fatalf("Service failed: %s", err)
+	}
 
-	log.Info("Shutting down...")
+	log.Info("Shut down")
 }
 
 func handleButtonEvent(e nexmosphere.Event) {
@@ -75,13 +73,13 @@ func handleButtonEvent(e nexmosphere.Event) {
 		e.Controller, e.Address, e.Action, e.Data, durationStr)
 
 	// Example: Execute custom logic based on button press
-	if e.Action == "press" {
-		buttonNum := e.Data
-		fmt.Printf("   → Button %s was pressed! Execute your logic here.\n", buttonNum)
-	} else if e.Action == "hold" {
+	b, _ := e.Button()
+	if e.Action == nexmosphere.ActionPress {
+		fmt.Printf("   → Button %d was pressed! Execute your logic here.\n", b.Button)
+	} else if e.Action == nexmosphere.ActionHold {
 		// Hold events fire periodically if HoldTickInterval is configured on the device
 		fmt.Printf("   → Button is being held for %s\n", e.Duration)
-	} else if e.Action == "release" {
+	} else if e.Action == nexmosphere.ActionRelease {
 		// Release event (paired with open) includes total hold duration
 		if e.Duration > 0 {
 			fmt.Printf("   → Button was held for a total of %s\n", e.Duration)
@@ -93,9 +91,9 @@ func handleRFIDTagEvent(e nexmosphere.Event) {
 	fmt.Printf("🏷️  RFID TAG Address %d: %s\n", e.Address, e.Action)
 
 	// Example: Track inventory or trigger actions based on tag pickup/putback
-	if e.Action == "pickup" {
+	if e.Action == nexmosphere.ActionPickup {
 		fmt.Printf("   → Tag %d picked up. Update inventory system.\n", e.Address)
-	} else if e.Action == "putback" {
+	} else if e.Action == nexmosphere.ActionPutback {
 		fmt.Printf("   → Tag %d put back. Restore inventory.\n", e.Address)
 	}
 }
@@ -110,13 +108,13 @@ func handlePresenceEvent(e nexmosphere.Event) {
 		e.Controller, e.Address, e.Action, e.Data)
 
 	// Example: Track customer presence in retail environment
-	if e.Action == "detection-zone" {
-		fmt.Printf("   → Presence detected in zone %s\n", e.Data)
+	if p, ok := e.Presence(); ok && e.Action == nexmosphere.ActionDetectionZone {
+		fmt.Printf("   → Presence detected in zone %d\n", p.Zone)
 	}
 }
 
 func handleControllerEvent(e nexmosphere.Event) {
-	if e.Action == "ready" {
+	if e.Action == nexmosphere.ActionReady {
 		fmt.Printf("✅ CONTROLLER READY: %s - %s\n", e.Controller, e.Data)
 	} else {
 		fmt.Printf("🎛️  CONTROLLER: %s (%s)\n", e.Action, e.Data)
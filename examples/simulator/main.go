@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+	"github.org/carrierlabs/go-nexmosphere/simulator"
+	"go.uber.org/zap"
+)
+
+func main() {
+	// Setup logger
+	logger, _ := zap.NewDevelopment()
+	log := logger.Sugar()
+	defer log.Sync()
+
+	log.Info("Nexmosphere Simulator Example")
+	log.Info("=============================")
+
+	// Create a virtual XN-185 with a button interface, RFID reader and presence sensor
+	sim := simulator.New("sim0",
+		simulator.WithDevice(1, "XTB4N6", "B4-0001"),
+		simulator.WithDevice(2, "XRDR1", "RD-0001"),
+		simulator.WithDevice(3, "XY240", "XY-0001"),
+	)
+
+	// Create Nexmosphere service without USB discovery, the simulator needs no settle time
+	service := nexmosphere.NewService(
+		nexmosphere.WithLogger(log),
+		nexmosphere.WithAutoScan(false),
+		nexmosphere.WithSettleDelay(0),
+	)
+
+	ready := make(chan struct{})
+	service.AddHandler(nexmosphere.EventHandlerFunc(func(e nexmosphere.Event) {
+		fmt.Printf("EVENT [%s] %-12s %-14s address=%d data=%s\n",
+			e.Controller, e.Type, e.Action, e.Address, e.Data)
+
+		if e.Type == nexmosphere.TypeController && e.Action == nexmosphere.ActionReady {
+			close(ready)
+		}
+	}))
+
+	// Start the service
+	if err := service.Start(); err != nil {
+		log.Fatalf("Failed to start service: %s", err)
+	}
+	defer service.Stop()
+
+	// Attach the simulator instead of scanning for hardware
+	if err := service.AddController(sim); err != nil {
+		log.Fatalf("Failed to add simulator: %s", err)
+	}
+
+	log.Info("Waiting for controller to become ready...")
+	<-ready
+
+	// Script some device activity
+	sim.PressButton(1, 2)
+	time.Sleep(1200 * time.Millisecond)
+	sim.ReleaseButton(1, 2)
+
+	sim.Putback(2, 7)
+	sim.Pickup(2, 7)
+
+	sim.SetZone(3, 2)
+
+	time.Sleep(500 * time.Millisecond)
+
+	fmt.Println("Commands written by the library:")
+	for _, cmd := range sim.Commands() {
+		fmt.Printf("  %s\n", cmd)
+	}
+}
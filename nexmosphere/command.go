@@ -0,0 +1,162 @@
+package nexmosphere
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidCommand is returned for commands that can't be encoded as a valid frame
+var ErrInvalidCommand = errors.New("invalid command")
+
+// Command is a command that can be sent to a controller
+type Command interface {
+	// Encode returns the wire form of the command, without line terminator
+	Encode() (string, error)
+}
+
+// XTalkCommand sends data to an X-Talk device
+//
+//	XTalkCommand{Address: 1, Format: "A", Data: "5"}  ->  X001A[5]
+//	XTalkCommand{Address: 2, Format: "B"}             ->  X002B[]
+type XTalkCommand struct {
+	Address int    // Device address (1-999)
+	Format  string // "A" for numeric data, "B" for text data
+	Data    string
+}
+
+// Encode returns the wire form of the command
+func (x XTalkCommand) Encode() (string, error) {
+	if err := validateAddress(x.Address, 1); err != nil {
+		return "", err
+	}
+
+	switch x.Format {
+	case "A":
+		if !isNumeric(x.Data) {
+			return "", fmt.Errorf("%w: format A data must be numeric, got %q", ErrInvalidCommand, x.Data)
+		}
+	case "B":
+		if err := validateData(x.Data); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("%w: unknown X-Talk format %q", ErrInvalidCommand, x.Format)
+	}
+
+	return fmt.Sprintf("X%03d%s[%s]", x.Address, x.Format, x.Data), nil
+}
+
+// DiagnosticQuery requests information from a device, or from the controller itself at address 0
+//
+//	DiagnosticQuery{Address: 1, Key: "TYPE"}  ->  D001B[TYPE]
+type DiagnosticQuery struct {
+	Address int    // Device address (0-999)
+	Key     string // Information requested, e.g. "TYPE" or "SERIAL"
+}
+
+// Encode returns the wire form of the query
+func (d DiagnosticQuery) Encode() (string, error) {
+	if err := validateAddress(d.Address, 0); err != nil {
+		return "", err
+	}
+
+	if d.Key == "" || strings.IndexFunc(d.Key, func(r rune) bool {
+		return !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) >= 0 {
+		return "", fmt.Errorf("%w: diagnostic key must be upper case alphanumeric, got %q", ErrInvalidCommand, d.Key)
+	}
+
+	return fmt.Sprintf("D%03dB[%s]", d.Address, d.Key), nil
+}
+
+// SettingCommand writes a setting on an X-Talk device
+//
+//	SettingCommand{Address: 1, Setting: "3", Value: "1"}  ->  X001S[3:1]
+type SettingCommand struct {
+	Address int    // Device address (1-999)
+	Setting string // Setting number
+	Value   string // Setting value
+}
+
+// Encode returns the wire form of the command
+func (s SettingCommand) Encode() (string, error) {
+	if err := validateAddress(s.Address, 1); err != nil {
+		return "", err
+	}
+
+	if !isNumeric(s.Setting) {
+		return "", fmt.Errorf("%w: setting must be numeric, got %q", ErrInvalidCommand, s.Setting)
+	}
+
+	if s.Value == "" || strings.Contains(s.Value, ":") {
+		return "", fmt.Errorf("%w: invalid setting value %q", ErrInvalidCommand, s.Value)
+	}
+	if err := validateData(s.Value); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("X%03dS[%s:%s]", s.Address, s.Setting, s.Value), nil
+}
+
+// ParseCommand validates a raw command string and returns its typed form
+func ParseCommand(raw string) (Command, error) {
+	fb, err := ParseFrame(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCommand, err)
+	}
+
+	var cmd Command
+	switch fb.Type + fb.Format {
+	case "XA", "XB":
+		cmd = XTalkCommand{Address: fb.Address, Format: fb.Format, Data: fb.Command}
+	case "XS":
+		parts := strings.SplitN(fb.Command, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%w: setting must be in setting:value form, got %q", ErrInvalidCommand, fb.Command)
+		}
+		cmd = SettingCommand{Address: fb.Address, Setting: parts[0], Value: parts[1]}
+	case "DB":
+		cmd = DiagnosticQuery{Address: fb.Address, Key: fb.Command}
+	default:
+		return nil, fmt.Errorf("%w: unsupported command %q", ErrInvalidCommand, raw)
+	}
+
+	// Check the content is valid for the command type
+	if _, err := cmd.Encode(); err != nil {
+		return nil, err
+	}
+
+	return cmd, nil
+}
+
+// validateAddress checks a device address is in range
+func validateAddress(address int, min int) error {
+	if address < min || address > 999 {
+		return fmt.Errorf("%w: invalid device address %d (must be %d-999)", ErrInvalidCommand, address, min)
+	}
+	return nil
+}
+
+// validateData checks command data can't break the frame
+func validateData(data string) error {
+	for _, r := range data {
+		if r < ' ' || r > '~' || r == '[' || r == ']' {
+			return fmt.Errorf("%w: invalid character %q in data", ErrInvalidCommand, r)
+		}
+	}
+	return nil
+}
+
+// isNumeric returns true for a non-empty string of decimal digits
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+package nexmosphere_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+)
+
+func TestCommandEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  nexmosphere.Command
+		want string
+	}{
+		{"x-talk numeric", nexmosphere.XTalkCommand{Address: 1, Format: "A", Data: "5"}, "X001A[5]"},
+		{"x-talk text", nexmosphere.XTalkCommand{Address: 999, Format: "B", Data: "LED2=BLINK"}, "X999B[LED2=BLINK]"},
+		{"x-talk empty text", nexmosphere.XTalkCommand{Address: 2, Format: "B"}, "X002B[]"},
+		{"x-talk text with spaces", nexmosphere.XTalkCommand{Address: 3, Format: "B", Data: "d1 d2"}, "X003B[d1 d2]"},
+		{"diagnostic controller", nexmosphere.DiagnosticQuery{Address: 0, Key: "TYPE"}, "D000B[TYPE]"},
+		{"diagnostic device", nexmosphere.DiagnosticQuery{Address: 12, Key: "SERIAL"}, "D012B[SERIAL]"},
+		{"diagnostic digits", nexmosphere.DiagnosticQuery{Address: 1, Key: "FW2"}, "D001B[FW2]"},
+		{"setting", nexmosphere.SettingCommand{Address: 1, Setting: "3", Value: "1"}, "X001S[3:1]"},
+		{"setting text value", nexmosphere.SettingCommand{Address: 45, Setting: "12", Value: "ON"}, "X045S[12:ON]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cmd.Encode()
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Encode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandEncodeRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  nexmosphere.Command
+	}{
+		{"x-talk address 0", nexmosphere.XTalkCommand{Address: 0, Format: "A", Data: "1"}},
+		{"x-talk address 1000", nexmosphere.XTalkCommand{Address: 1000, Format: "A", Data: "1"}},
+		{"x-talk negative address", nexmosphere.XTalkCommand{Address: -1, Format: "A", Data: "1"}},
+		{"x-talk unknown format", nexmosphere.XTalkCommand{Address: 1, Format: "C", Data: "1"}},
+		{"x-talk no format", nexmosphere.XTalkCommand{Address: 1, Data: "1"}},
+		{"x-talk lower case format", nexmosphere.XTalkCommand{Address: 1, Format: "a", Data: "1"}},
+		{"x-talk numeric empty", nexmosphere.XTalkCommand{Address: 1, Format: "A"}},
+		{"x-talk numeric letters", nexmosphere.XTalkCommand{Address: 1, Format: "A", Data: "x"}},
+		{"x-talk numeric decimal", nexmosphere.XTalkCommand{Address: 1, Format: "A", Data: "1.5"}},
+		{"x-talk numeric negative", nexmosphere.XTalkCommand{Address: 1, Format: "A", Data: "-1"}},
+		{"x-talk text closing bracket", nexmosphere.XTalkCommand{Address: 1, Format: "B", Data: "a]b"}},
+		{"x-talk text opening bracket", nexmosphere.XTalkCommand{Address: 1, Format: "B", Data: "a[b"}},
+		{"x-talk text line break", nexmosphere.XTalkCommand{Address: 1, Format: "B", Data: "a\r\nX002A[1]"}},
+		{"x-talk text non-ASCII", nexmosphere.XTalkCommand{Address: 1, Format: "B", Data: "é"}},
+		{"diagnostic negative address", nexmosphere.DiagnosticQuery{Address: -1, Key: "TYPE"}},
+		{"diagnostic address 1000", nexmosphere.DiagnosticQuery{Address: 1000, Key: "TYPE"}},
+		{"diagnostic empty key", nexmosphere.DiagnosticQuery{Address: 1}},
+		{"diagnostic lower case key", nexmosphere.DiagnosticQuery{Address: 1, Key: "type"}},
+		{"diagnostic key with space", nexmosphere.DiagnosticQuery{Address: 1, Key: "TY PE"}},
+		{"diagnostic key with bracket", nexmosphere.DiagnosticQuery{Address: 1, Key: "TYPE]"}},
+		{"setting address 0", nexmosphere.SettingCommand{Address: 0, Setting: "3", Value: "1"}},
+		{"setting empty", nexmosphere.SettingCommand{Address: 1, Value: "1"}},
+		{"setting not numeric", nexmosphere.SettingCommand{Address: 1, Setting: "a", Value: "1"}},
+		{"setting empty value", nexmosphere.SettingCommand{Address: 1, Setting: "3"}},
+		{"setting value with colon", nexmosphere.SettingCommand{Address: 1, Setting: "3", Value: "1:2"}},
+		{"setting value with bracket", nexmosphere.SettingCommand{Address: 1, Setting: "3", Value: "1]"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cmd.Encode()
+			if !errors.Is(err, nexmosphere.ErrInvalidCommand) {
+				t.Errorf("Encode() = %q, %v, want an error wrapping ErrInvalidCommand", got, err)
+			}
+		})
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want nexmosphere.Command
+	}{
+		{"X001A[5]", nexmosphere.XTalkCommand{Address: 1, Format: "A", Data: "5"}},
+		{"X999B[LED2=BLINK]", nexmosphere.XTalkCommand{Address: 999, Format: "B", Data: "LED2=BLINK"}},
+		{"X002B[]", nexmosphere.XTalkCommand{Address: 2, Format: "B"}},
+		{"D000B[TYPE]", nexmosphere.DiagnosticQuery{Address: 0, Key: "TYPE"}},
+		{"D012B[SERIAL]", nexmosphere.DiagnosticQuery{Address: 12, Key: "SERIAL"}},
+		{"X001S[3:1]", nexmosphere.SettingCommand{Address: 1, Setting: "3", Value: "1"}},
+		{"X045S[12:ON]", nexmosphere.SettingCommand{Address: 45, Setting: "12", Value: "ON"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			cmd, err := nexmosphere.ParseCommand(tt.raw)
+			if err != nil {
+				t.Fatalf("ParseCommand() error = %v", err)
+			}
+			if !reflect.DeepEqual(cmd, tt.want) {
+				t.Errorf("ParseCommand() = %#v, want %#v", cmd, tt.want)
+			}
+
+			// The typed form encodes back to the raw command
+			encoded, err := cmd.Encode()
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if encoded != tt.raw {
+				t.Errorf("Encode() = %q, want %q", encoded, tt.raw)
+			}
+		})
+	}
+}
+
+func TestParseCommandRejected(t *testing.T) {
+	tests := []string{
+		"",
+		"garbage",
+		"X001A[5",
+		"X001A[x]",
+		"X000A[1]",
+		"X001C[1]",
+		"X001S[3]",
+		"X001S[a:1]",
+		"X001S[3:]",
+		"D001A[TYPE]",
+		"D001B[type]",
+		"XR[PB007]",
+	}
+
+	for _, raw := range tests {
+		t.Run(raw, func(t *testing.T) {
+			cmd, err := nexmosphere.ParseCommand(raw)
+			if !errors.Is(err, nexmosphere.ErrInvalidCommand) {
+				t.Errorf("ParseCommand() = %#v, %v, want an error wrapping ErrInvalidCommand", cmd, err)
+			}
+		})
+	}
+}
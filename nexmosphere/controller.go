@@ -2,12 +2,13 @@ package nexmosphere
 
 import (
 	"bufio"
+	"context"
 	"fmt"
-	"strconv"
+	"io"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"go.bug.st/serial"
 )
 
 type controllerMD struct {
@@ -17,60 +18,69 @@ type controllerMD struct {
 	pid         string
 }
 
-type queue int
+// probeQuery asks the controller itself (address 0) for its product code
+var probeQuery = DiagnosticQuery{Address: 0, Key: "TYPE"}
 
-const (
-	systemQueue queue = iota
-	commandQueue
-)
+const probeReplyPrefix = "TYPE="
+const probeRetryInterval = time.Second
 
 // Controller manages communication with a Nexmosphere controller
 type Controller struct {
-	isUSB                bool
-	port                 serial.Port
-	name                 string
-	md                   controllerMD
-	devices              [1000]*Device
-	lastFB               *feedback
-	queue                [2][]string
-	qTimer               *time.Ticker
-	service              *Service
-	ready                bool
-	pendingDeviceQueries int
-}
-
-type feedback struct {
-	Type    string
-	Format  string
-	Command string
-	Address int
-	Action  string
-	Data    string
-	Raw     string
-}
-
-// addToQueue adds a command to the queue
-func (c *Controller) addToQueue(q queue, cmd string) {
-	c.queue[q] = append(c.queue[q], cmd)
+	rejectedFrames uint64 // Accessed atomically, first for 64-bit alignment on 32-bit platforms
+	isUSB          bool
+	kind           string
+	port           Transport
+	reader         *bufio.Reader
+	pmu            sync.Mutex // Guards port, connected and lost, the port is swapped on reconnect
+	connected      bool
+	lost           int                       // Number of times the connection has been lost
+	reopen         func() (Transport, error) // Reopens the same port identity, nil if it can't be reopened
+	id             string                    // Stable identity, see controllerID
+	name           string                    // Alias if configured, otherwise the ID
+	md             controllerMD
+	mu             sync.Mutex // Guards devices, lastTag, ready, pendingDevices, identifying and events
+	devices        [1000]*Device
+	lastTag        *Frame // Last XR frame, the tag the next antenna frame refers to
+	queue          [2][]queuedCommand
+	qmu            sync.Mutex
+	queued         chan struct{}             // Signalled when a command is queued or the connection is restored
+	settings       map[int]map[string]string // Settings written to devices, restored on reconnect
+	waiters        map[queryKey][]chan string
+	wmu            sync.Mutex
+	service        *Service
+	ready          bool
+	pendingDevices map[int]bool      // Probed addresses that haven't reported their type yet
+	identifying    map[int]time.Time // Unknown devices with a type query in flight
+	events         []Event           // Events raised while mu is held, dispatched by unlock
+	dmu            sync.Mutex        // Held while dispatching, so events are dispatched in the order they were raised
+	done           chan struct{}
 }
 
-// getFromQueue returns the next command from the queue
-func (c *Controller) getFromQueue() string {
-	var q queue
-	var x string
-	switch {
-	case len(c.queue[systemQueue]) > 0:
-		q = systemQueue
-	case len(c.queue[commandQueue]) > 0:
-		q = commandQueue
-	default:
-		return ""
+// newController creates a controller communicating over a transport
+func newController(s *Service, t Transport) *Controller {
+	info := t.Info()
+	return &Controller{
+		md: controllerMD{
+			serialNo:    info.SerialNumber,
+			productCode: "",
+			vid:         info.VID,
+			pid:         info.PID,
+		},
+		id:          controllerID(info),
+		name:        controllerID(info),
+		isUSB:       info.IsUSB,
+		kind:        info.Kind,
+		port:        t,
+		reader:      bufio.NewReader(t),
+		connected:   true,
+		service:     s,
+		identifying: make(map[int]time.Time),
+		queued:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
 	}
-	x, c.queue[q] = c.queue[q][0], c.queue[q][1:]
-	return x
 }
 
-// getDevice returns a device by address
+// getDevice returns a device by address, must be called with mu held
 func (c *Controller) getDevice(i int) *Device {
 	if i >= 0 && i < 1000 {
 		if c.devices[i] == nil {
@@ -83,60 +93,180 @@ func (c *Controller) getDevice(i int) *Device {
 	return nil
 }
 
-// decodeFeedback decodes a raw feedback string into a feedback struct
-func (c *Controller) decodeFeedback(data string) *feedback {
-	fb := &feedback{
-		Raw:     data,
-		Command: strings.TrimSpace(data[strings.Index(data, `[`)+1 : strings.Index(data, `]`)]),
+// listen reads incoming buffer for complete commands (terminated by CR+LF)
+func (c *Controller) listen() error {
+	for {
+		line, err := c.readLine()
+		if line != "" {
+			c.handleLine(line)
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 	}
+}
 
-	// Handle XR Sensors (RFID tags)
-	if strings.HasPrefix(data, "XR") {
-		fb.Type = "XR"
-		fb.Address, _ = strconv.Atoi(fb.Command[2:])
-		return fb
+// readLine returns the next line without its terminator
+// Lines longer than maxFrameLength are truncated so line noise can't exhaust memory
+func (c *Controller) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := c.reader.ReadSlice('\n')
+		if len(line) <= maxFrameLength {
+			line = append(line, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return strings.TrimRight(string(line), "\r\n"), err
 	}
+}
 
-	fb.Type = data[0:1]
-	fb.Address, _ = strconv.Atoi(data[1:4])
-	fb.Format = data[4:5]
+// handleLine parses a line of feedback and dispatches the resulting event
+func (c *Controller) handleLine(line string) {
+	// Ignore blank lines between frames
+	if strings.TrimSpace(line) == "" {
+		return
+	}
 
-	return fb
-}
+	fb, err := ParseFrame(line)
+	if err != nil {
+		atomic.AddUint64(&c.rejectedFrames, 1)
+		c.service.logger.Debugf("Rejected frame from %s: %s", c.name, err)
+		c.service.dispatch(Event{
+			Type:       TypeController,
+			Controller: c.name,
+			Action:     ActionParseError,
+			Data:       err.Error(),
+			Raw:        line,
+		})
+		return
+	}
 
-// listen scans incoming buffer for complete commands (terminated by CR+LF)
-func (c *Controller) listen() error {
-	scanner := bufio.NewScanner(c.port)
+	var events []Event
 
-	for scanner.Scan() {
-		fb := c.decodeFeedback(scanner.Text())
+	c.mu.Lock()
+	defer c.unlock()
 
-		eventType := "unhandled"
-		var event *Event
+	switch fb.Type {
+	case "XR": // XR Antenna (RFID tag events)
+		events = c.doXRfb(&fb)
+	case "X": // X-Talk Command (device events)
+		events = c.doXfb(&fb)
+	case "D": // Diagnostic Command
+		events = c.doDiagnosticfb(&fb)
+	}
 
-		switch fb.Type {
-		case "XR": // XR Antenna (RFID tag events)
-			eventType, event = c.doXRfb(fb)
-		case "X": // X-Talk Command (device events)
-			eventType, event = c.doXfb(fb)
-		case "D": // Diagnostic Command
-			eventType, event = c.doDiagnosticfb(fb)
+	for _, event := range events {
+		event.Controller = c.name
+		// XR frames are addressed by tag number rather than device
+		if fb.Type != "XR" && fb.Address > 0 && event.DeviceType == "" {
+			event.DeviceType = c.getDevice(fb.Address).Type
 		}
+		c.emit(event)
+	}
+	if fb.Type == "XR" {
+		c.lastTag = &fb
+	}
+}
 
-		if event != nil {
-			event.Type = eventType
-			event.Controller = c.name
-			c.service.dispatch(*event)
-			c.lastFB = fb
-		}
+// emit records an event to dispatch once mu is released, must be called with mu held
+// Dispatching while holding mu could deadlock against the service lock
+func (c *Controller) emit(event Event) {
+	c.events = append(c.events, event)
+}
+
+// unlock releases mu and dispatches the events raised while it was held
+// dmu is taken before mu is released so events raised later can't overtake them
+func (c *Controller) unlock() {
+	events := c.events
+	c.events = nil
+	c.dmu.Lock()
+	defer c.dmu.Unlock()
+	c.mu.Unlock()
+
+	for _, event := range events {
+		c.service.dispatch(event)
 	}
+}
 
-	return scanner.Err()
+// isReady returns true once the initial device enumeration has completed
+func (c *Controller) isReady() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ready
+}
+
+// probe validates the port is a Nexmosphere controller by querying its type
+// The query is repeated until a well-formed reply arrives or the timeout expires,
+// on timeout the port is closed. Returns the controller product code
+func (c *Controller) probe(ctx context.Context, timeout time.Duration) (string, error) {
+	type result struct {
+		model string
+		err   error
+	}
+
+	query, err := probeQuery.Encode()
+	if err != nil {
+		return "", err
+	}
+
+	reader := c.reader
+	replies := make(chan result, 1)
+	c.service.spawn(func() {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				replies <- result{err: err}
+				return
+			}
+
+			// Skip anything but a well-formed reply from the controller itself
+			fb, err := ParseFrame(line)
+			if err != nil || fb.Type != "D" || fb.Address != 0 {
+				continue
+			}
+			if model := strings.TrimPrefix(fb.Command, probeReplyPrefix); model != fb.Command && model != "" {
+				replies <- result{model: model}
+				return
+			}
+		}
+	})
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	retry := time.NewTicker(probeRetryInterval)
+	defer retry.Stop()
+
+	c.write(query)
+	for {
+		select {
+		case r := <-replies:
+			return r.model, r.err
+		case <-retry.C:
+			c.write(query)
+		case <-deadline.C:
+			c.close()
+			return "", fmt.Errorf("no reply to %s within %s", query, timeout)
+		case <-ctx.Done():
+			c.close()
+			return "", ctx.Err()
+		}
+	}
 }
 
 // write sends a command to controller
 func (c *Controller) write(cmd string) error {
-	_, err := c.port.Write([]byte(fmt.Sprintf("%s\r\n", cmd)))
+	c.pmu.Lock()
+	port := c.port
+	c.pmu.Unlock()
+
+	_, err := port.Write([]byte(fmt.Sprintf("%s\r\n", cmd)))
 	if err != nil {
 		c.service.logger.Errorf("can't write to serial %s: %s", c.name, err)
 		return err
@@ -144,125 +274,256 @@ func (c *Controller) write(cmd string) error {
 	return nil
 }
 
-// close closes the controller port and stops the queue timer
+// close closes the controller port
 func (c *Controller) close() error {
-	if c.qTimer != nil {
-		c.qTimer.Stop()
-		c.qTimer = nil
+	c.pmu.Lock()
+	defer c.pmu.Unlock()
+
+	if c.connected {
+		c.lost++
 	}
+	c.connected = false
 	if c.port != nil {
 		return c.port.Close()
 	}
 	return nil
 }
 
+// setTransport replaces the port of a controller that has been reopened
+func (c *Controller) setTransport(t Transport) {
+	c.pmu.Lock()
+	defer c.pmu.Unlock()
+
+	c.port = t
+	c.reader = bufio.NewReader(t)
+}
+
+// portName returns the name of the port the controller is currently connected through
+func (c *Controller) portName() string {
+	c.pmu.Lock()
+	defer c.pmu.Unlock()
+	return c.port.Info().Name
+}
+
+// setConnected records whether the controller is connected and accepting commands
+func (c *Controller) setConnected(connected bool) {
+	c.pmu.Lock()
+	defer c.pmu.Unlock()
+	c.connected = connected
+
+	// Commands held while reconnecting can be written now
+	if connected {
+		c.signalQueue()
+	}
+}
+
+// connection returns whether the controller is connected and how many times the connection has been lost
+func (c *Controller) connection() (connected bool, lost int) {
+	c.pmu.Lock()
+	defer c.pmu.Unlock()
+	return c.connected, c.lost
+}
+
+// isConnected returns true if the controller is connected
+func (c *Controller) isConnected() bool {
+	c.pmu.Lock()
+	defer c.pmu.Unlock()
+	return c.connected
+}
+
 // getInfo returns controller information
 func (c *Controller) getInfo() ControllerInfo {
+	c.mu.Lock()
 	deviceCount := 0
-	for _, d := range c.devices {
-		if d != nil && d.Type != "" {
+	for i, d := range c.devices {
+		if i > 0 && d != nil && d.Type != "" {
 			deviceCount++
 		}
 	}
+	c.mu.Unlock()
+
+	alias := ""
+	if c.name != c.id {
+		alias = c.name
+	}
 
 	return ControllerInfo{
-		Name:        c.name,
-		IsUSB:       c.isUSB,
-		VID:         c.md.vid,
-		PID:         c.md.pid,
-		DeviceCount: deviceCount,
+		Name:           c.name,
+		ID:             c.id,
+		Alias:          alias,
+		Port:           c.portName(),
+		SerialNo:       c.md.serialNo,
+		ProductCode:    c.md.productCode,
+		Kind:           c.kind,
+		IsUSB:          c.isUSB,
+		VID:            c.md.vid,
+		PID:            c.md.pid,
+		Connected:      c.isConnected(),
+		DeviceCount:    deviceCount,
+		RejectedFrames: atomic.LoadUint64(&c.rejectedFrames),
 	}
 }
 
-// doXfb handles X-Talk feedback (device events)
-func (c *Controller) doXfb(fb *feedback) (string, *Event) {
+// doXfb handles X-Talk feedback (device events), decoded by the driver for the device type
+func (c *Controller) doXfb(fb *Frame) []Event {
 	d := c.getDevice(fb.Address)
 	if d == nil {
-		return "unknown", nil
+		return nil
 	}
+	d.LastSeen = time.Now()
 
-	switch d.Type {
-	case "XTB4N6": // 4 Button XT-B4
-		return "button", d.processFbXTB4N6(fb, c)
+	// Device plugged in since the controller was enumerated
+	if d.Type == "" {
+		c.identify(fb.Address)
+		return nil
+	}
 
-	case "XRDR1": // RFID Reader
-		return "rfid-antenna", c.processFbXRDR1(fb)
+	driver := driverFor(d.Type)
+	if driver == nil {
+		return nil
+	}
 
-	case "XY240": // X-Eye Presence & Airbutton
-		return "presence", d.processFbXY240(fb)
+	// Drivers get a copy of the last tag frame so they can't alter it
+	var tag *Frame
+	if c.lastTag != nil {
+		t := *c.lastTag
+		tag = &t
+	}
 
-	default:
-		return "unknown", nil
+	events := driver.Decode(d, *fb, tag)
+	for i := range events {
+		events[i].Address = fb.Address
+		events[i].Raw = fb.Raw
+		c.trackHold(d, events[i])
 	}
+	return events
 }
 
 // doXRfb handles XR feedback (RFID tag events)
-func (c *Controller) doXRfb(fb *feedback) (string, *Event) {
-	event := &Event{
+func (c *Controller) doXRfb(fb *Frame) []Event {
+	event := Event{
+		Type:    TypeRFIDTag,
 		Address: fb.Address,
 		Raw:     fb.Raw,
 	}
 
 	switch fb.Command[0:2] {
 	case "PU":
-		event.Action = "pickup"
+		event.Action = ActionPickup
 	case "PB":
-		event.Action = "putback"
+		event.Action = ActionPutback
 	default:
-		event.Action = "unknown"
+		event.Action = ActionUnknown
 	}
 
-	return "rfid-tag", event
+	return []Event{event}
 }
 
 // doDiagnosticfb handles diagnostic feedback
-func (c *Controller) doDiagnosticfb(fb *feedback) (string, *Event) {
+func (c *Controller) doDiagnosticfb(fb *Frame) []Event {
 	// Split up the command
-	s := strings.Split(fb.Command, "=")
+	s := strings.SplitN(fb.Command, "=", 2)
 
 	// Return if the command is out of scope
 	if len(s) < 2 || fb.Address > 999 {
-		return "system-unhandled", nil
+		return nil
+	}
+
+	// Address 0 is the controller itself, not a device
+	if fb.Address == 0 {
+		c.resolveQuery(fb.Address, s[0], s[1])
+		return nil
 	}
 
 	d := c.getDevice(fb.Address)
 	if d == nil {
-		return "system-unhandled", nil
+		return nil
 	}
+	d.LastSeen = time.Now()
 
 	// Save diagnostics data against correct device
 	switch s[0] {
 	case "TYPE":
+		previous := d.Type
 		d.Type = s[1]
-		// For RFID readers, request status
-		if s[1] == "XRDR1" {
-			c.addToQueue(systemQueue, fmt.Sprintf("X%03dB[]", fb.Address))
+		delete(c.identifying, fb.Address)
+		if previous != s[1] {
+			d.resetState()
+		}
+
+		// Report devices connected or swapped after the controller is ready
+		if c.ready && previous != s[1] {
+			if previous != "" {
+				c.emit(Event{
+					Type:       TypeDevice,
+					Controller: c.name,
+					Address:    fb.Address,
+					DeviceType: previous,
+					Action:     ActionDetached,
+					Data:       fmt.Sprintf("TYPE=%s", previous),
+				})
+			}
+			c.service.logger.Infof("Device %s attached to %s address %d", s[1], c.name, fb.Address)
+			c.emit(Event{
+				Type:       TypeDevice,
+				Controller: c.name,
+				Address:    fb.Address,
+				DeviceType: s[1],
+				Action:     ActionAttached,
+				Data:       fmt.Sprintf("TYPE=%s", s[1]),
+				Raw:        fb.Raw,
+			})
+		}
+
+		// For newly found devices, request their details and let the driver initialise them
+		if previous != s[1] {
+			c.addToQueue(systemQueue, DiagnosticQuery{Address: fb.Address, Key: "SERIAL"})
+			c.addToQueue(systemQueue, DiagnosticQuery{Address: fb.Address, Key: "FW"})
+			c.initDevice(fb.Address, s[1])
 		}
 		// Track device query completion
-		if c.pendingDeviceQueries > 0 {
-			c.pendingDeviceQueries--
-			if c.pendingDeviceQueries == 0 && !c.ready {
+		if c.pendingDevices[fb.Address] {
+			delete(c.pendingDevices, fb.Address)
+			if len(c.pendingDevices) == 0 && !c.ready {
 				c.ready = true
 				c.service.logger.Infof("Controller %s ready - all devices initialized", c.name)
-				c.service.dispatch(Event{
-					Type:       "controller",
+				c.emit(Event{
+					Type:       TypeController,
 					Controller: c.name,
-					Action:     "ready",
+					Action:     ActionReady,
 					Data:       "All devices initialized",
 				})
 			}
 		}
 	case "SERIAL":
 		d.Serial = s[1]
+	case "FW":
+		d.Firmware = s[1]
 	}
 
+	// Answer any pending queries
+	c.resolveQuery(fb.Address, s[0], s[1])
+
 	// Create device update event
-	event := &Event{
+	event := Event{
+		Type:    TypeDevice,
 		Address: fb.Address,
-		Action:  "update",
+		Action:  ActionUpdate,
 		Data:    fb.Command,
 		Raw:     fb.Raw,
 	}
 
-	return "device", event
+	return []Event{event}
+}
+
+// initDevice queues the commands the driver for a device type sends on discovery
+func (c *Controller) initDevice(address int, deviceType string) {
+	driver := driverFor(deviceType)
+	if driver == nil {
+		return
+	}
+
+	for _, cmd := range driver.Init(address) {
+		c.addToQueue(systemQueue, cmd)
+	}
 }
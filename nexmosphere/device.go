@@ -2,7 +2,7 @@ package nexmosphere
 
 import (
 	"fmt"
-	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -13,7 +13,10 @@ type Button struct {
 	Closed     bool          // Physical button state (wire closed)
 	Pressed    bool          // Logical button state (debounced)
 	PressedAt  time.Time     // Timestamp when button was pressed
+	LED        LEDMode       // Last mode set with SetButtonLED
 	holdCancel chan struct{} // Channel to stop hold ticker goroutine
+	ledQueued  uint64        // Sequence number of the last LED command queued
+	ledWritten uint64        // Sequence number of the LED command LED was set from
 }
 
 const buttonCount int = 4
@@ -23,15 +26,38 @@ const defaultHoldTickInterval = 500 * time.Millisecond
 type Device struct {
 	Type             string
 	Serial           string
+	Firmware         string
+	LastSeen         time.Time // Time of the last feedback from the device
 	Button           [buttonCount]Button
+	Tags             map[int]bool  // Tags on an XRDR1 antenna
+	Zone             int           // XY240 detection zone
+	LEDFeedback      bool          // XTB4N6 LED feedback flag, bit 0 of the button state
 	HoldTickInterval time.Duration // Interval for emitting hold events (default: 500ms, set to 0 to disable)
+	State            interface{}   // Free for use by the driver of the device, cleared when the device changes
 }
 
-// setButton sets the state of a button and dispatches events
-func (d *Device) setButton(buttonID int, state bool, fb *feedback, c *Controller) {
+// resetState forgets the feedback state of a device that has been unplugged or swapped
+// Hold tickers are stopped, must be called with c.mu held
+func (d *Device) resetState() {
+	d.Serial = ""
+	d.Firmware = ""
+	d.Tags = nil
+	d.Zone = 0
+	d.LEDFeedback = false
+	d.State = nil
+	for i := range d.Button {
+		if d.Button[i].holdCancel != nil {
+			close(d.Button[i].holdCancel)
+		}
+		d.Button[i] = Button{}
+	}
+}
+
+// setButton sets the state of a button and returns the resulting events
+func (d *Device) setButton(buttonID int, state bool) []Event {
 	// Check if button exists
 	if buttonID > buttonCount || buttonID < 1 {
-		return
+		return nil
 	}
 
 	// Get pointer to button for easier access
@@ -39,7 +65,7 @@ func (d *Device) setButton(buttonID int, state bool, fb *feedback, c *Controller
 
 	// If state hasn't changed, return
 	if b.Closed == state {
-		return
+		return nil
 	}
 
 	// Set state
@@ -47,97 +73,231 @@ func (d *Device) setButton(buttonID int, state bool, fb *feedback, c *Controller
 
 	// Send raw switch update
 	event := Event{
-		Address: fb.Address,
-		Data:    fmt.Sprintf("%02d", buttonID),
-		Raw:     fb.Raw,
+		Type: TypeButton,
+		Data: fmt.Sprintf("%02d", buttonID),
 	}
 
 	if state {
-		event.Action = "closed"
+		event.Action = ActionClosed
 		// Record press time
 		b.PressedAt = time.Now()
 	} else {
-		event.Action = "open"
+		event.Action = ActionOpen
 		// Calculate hold duration
 		if !b.PressedAt.IsZero() {
 			event.Duration = time.Since(b.PressedAt)
 			b.PressedAt = time.Time{} // Reset
 		}
-		// Stop hold ticker if running
-		if b.holdCancel != nil {
-			close(b.holdCancel)
-			b.holdCancel = nil
-		}
 		// Reset pressed state
 		b.Pressed = false
 	}
 
-	event.Type = "button"
-	event.Controller = c.name
-	c.service.dispatch(event)
+	events := []Event{event}
 
 	// If button was opened (released), also send logical "release" event
 	if !state {
 		releaseEvent := event
-		releaseEvent.Action = "release"
-		c.service.dispatch(releaseEvent)
+		releaseEvent.Action = ActionRelease
+		events = append(events, releaseEvent)
 	}
 
 	// If switch is closed, send pressed update
 	if b.Closed && !b.Pressed {
 		b.Pressed = true
-		event.Action = "press"
-		event.Type = "button"
-		event.Controller = c.name
-		c.service.dispatch(event)
+		event.Action = ActionPress
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// trackHold starts a hold ticker when a button is pressed and stops it when the button opens
+// Must be called with c.mu held
+func (c *Controller) trackHold(d *Device, event Event) {
+	payload, ok := event.Button()
+	if !ok || payload.Button < 1 || payload.Button > buttonCount {
+		return
+	}
+	b := &d.Button[payload.Button-1]
+
+	switch event.Action {
+	case ActionOpen:
+		// Stop hold ticker if running
+		if b.holdCancel != nil {
+			close(b.holdCancel)
+			b.holdCancel = nil
+		}
 
+	case ActionPress:
 		// Start hold ticker if interval configured
-		if d.HoldTickInterval > 0 {
-			b.holdCancel = make(chan struct{})
-			go func(ev Event, cancel chan struct{}) {
-				ticker := time.NewTicker(d.HoldTickInterval)
-				defer ticker.Stop()
-				for {
+		if d.HoldTickInterval <= 0 {
+			return
+		}
+
+		b.holdCancel = make(chan struct{})
+		ev, cancel, interval := event, b.holdCancel, d.HoldTickInterval
+		ev.Controller = c.name
+		ev.DeviceType = d.Type
+		c.service.spawn(func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					// Raised under the lock so a hold can't be dispatched after its release
+					c.mu.Lock()
 					select {
-					case <-ticker.C:
+					case <-cancel:
+					default:
 						if !b.PressedAt.IsZero() {
 							holdEvent := ev
-							holdEvent.Action = "hold"
+							holdEvent.Action = ActionHold
 							holdEvent.Duration = time.Since(b.PressedAt)
-							c.service.dispatch(holdEvent)
+							c.emit(holdEvent)
 						}
-					case <-cancel:
-						return
 					}
+					c.unlock()
+				case <-cancel:
+					return
+				case <-c.done:
+					return
 				}
-			}(event, b.holdCancel)
-		}
+			}
+		})
 	}
 }
 
-// processFbXTB4N6 processes feedback from XTB4N6 Push Button Interface
-func (d *Device) processFbXTB4N6(fb *feedback, c *Controller) *Event {
+// xtb4n6Driver decodes the XTB4N6 Push Button Interface
+type xtb4n6Driver struct{}
+
+// Match returns true for the XT-B4 button interface
+func (xtb4n6Driver) Match(deviceType string) bool {
+	return deviceType == "XTB4N6"
+}
+
+// Init returns no commands, button states are reported as they change
+func (xtb4n6Driver) Init(address int) []Command {
+	return nil
+}
+
+// xtb4n6MaxState is the largest button state an XTB4N6 reports, the LED flag and all buttons set
+const xtb4n6MaxState = 1<<(buttonCount+1) - 1
+
+// Decode updates the device from XTB4N6 feedback and returns the resulting events
+//
+// The button state is reported in format A as a decimal bitmask (0-31):
+//
+//	bit 0     LED feedback flag
+//	bits 1-4  buttons 1-4, set while the button is closed
+//
+// e.g. X001A[5] is button 2 closed with the LED flag set, X001A[6] buttons 1 and 2 closed.
+// Each changed button raises closed/press or open/release, and a chord event follows the
+// presses when a frame leaves more than one button held. Format B reports the LED of a
+// button, e.g. X001B[LED2=BLINK], which is recorded in the device model. Other feedback,
+// and states outside 0-31, are ignored
+func (xtb4n6Driver) Decode(d *Device, fb Frame, tag *Frame) []Event {
 	switch fb.Format {
 	case "A":
-		// Get raw button state
-		i, _ := strconv.Atoi(fb.Command)
-		// If greater than 0 adjust
-		if i > 0 {
-			i = i - 1
+		state, err := strconv.Atoi(fb.Command)
+		if err != nil || state < 0 || state > xtb4n6MaxState {
+			return nil
+		}
+		d.LEDFeedback = state&1 != 0
+
+		var events []Event
+		pressed := false
+		for b := 1; b <= buttonCount; b++ {
+			changed := d.setButton(b, state&(1<<b) != 0)
+			for _, event := range changed {
+				pressed = pressed || event.Action == ActionPress
+			}
+			events = append(events, changed...)
+		}
+
+		if pressed {
+			if chord, ok := d.chord(); ok {
+				events = append(events, chord)
+			}
+		}
+		return events
+
+	case "B":
+		key, value, ok := strings.Cut(fb.Command, "=")
+		if !ok || !strings.HasPrefix(key, "LED") {
+			return nil
+		}
+		button, err := strconv.Atoi(strings.TrimPrefix(key, "LED"))
+		if err != nil || button < 1 || button > buttonCount {
+			return nil
+		}
+		if mode, err := ParseLEDMode(value); err == nil {
+			d.Button[button-1].LED = mode
+		}
+	}
+	return nil
+}
+
+// chord returns a chord event listing the buttons held, e.g. "01+03", if more than one is
+func (d *Device) chord() (Event, bool) {
+	var held []string
+	for i, b := range d.Button {
+		if b.Pressed {
+			held = append(held, fmt.Sprintf("%02d", i+1))
 		}
-		// Set button states
-		for b := 1; b <= 4; b++ {
-			d.setButton(b, i&(int(math.Pow(2, float64(b)))) > 0, fb, c)
+	}
+	if len(held) < 2 {
+		return Event{}, false
+	}
+
+	return Event{
+		Type:   TypeButton,
+		Action: ActionChord,
+		Data:   strings.Join(held, "+"),
+	}, true
+}
+
+// Encode supports "led" to set the LED of a button, e.g. Encode(1, "led", "2", "blink")
+// Use Service.SetButtonLED to have the LED state tracked
+func (xtb4n6Driver) Encode(address int, name string, args ...string) (Command, error) {
+	switch name {
+	case "led":
+		return encodeLED(address, args)
+	}
+	return nil, fmt.Errorf("%w: XTB4N6 has no command %q", ErrInvalidCommand, name)
+}
+
+// Snapshot reports the button states and LED feedback flag
+func (xtb4n6Driver) Snapshot(d *Device, info *DeviceInfo) {
+	info.LEDFeedback = d.LEDFeedback
+	info.Buttons = make([]ButtonState, buttonCount)
+	for i, b := range d.Button {
+		info.Buttons[i] = ButtonState{
+			Button:    i + 1,
+			Closed:    b.Closed,
+			Pressed:   b.Pressed,
+			PressedAt: b.PressedAt,
+			LED:       b.LED,
 		}
 	}
+}
+
+// xy240Driver decodes the XY240 X-Eye Presence & AirButton Sensor
+type xy240Driver struct{}
+
+// Match returns true for the X-Eye presence sensor
+func (xy240Driver) Match(deviceType string) bool {
+	return deviceType == "XY240"
+}
+
+// Init returns no commands, zones are reported as they change
+func (xy240Driver) Init(address int) []Command {
 	return nil
 }
 
-// processFbXY240 processes feedback from XY240 X-Eye Presence & AirButton Sensor
-func (d *Device) processFbXY240(fb *feedback) *Event {
-	event := &Event{
-		Address: fb.Address,
-		Raw:     fb.Raw,
+// Decode records the detection zone and returns a detection-zone event
+func (xy240Driver) Decode(d *Device, fb Frame, tag *Frame) []Event {
+	event := Event{
+		Type: TypePresence,
 	}
 
 	switch fb.Format {
@@ -149,65 +309,110 @@ func (d *Device) processFbXY240(fb *feedback) *Event {
 		}
 		switch parts[0] {
 		case "Dz": // Detection Zone
-			event.Action = "detection-zone"
+			d.Zone, _ = strconv.Atoi(parts[1])
+			event.Action = ActionDetectionZone
 			event.Data = parts[1]
-			return event
+			return []Event{event}
 		}
 	}
 
 	return nil
 }
 
-// processFbXRDR1 processes feedback from XRDR1 RFID Reader
-func (c *Controller) processFbXRDR1(fb *feedback) *Event {
-	event := &Event{
-		Address: fb.Address,
-		Raw:     fb.Raw,
+// Encode has no device specific commands
+func (xy240Driver) Encode(address int, name string, args ...string) (Command, error) {
+	return nil, fmt.Errorf("%w: XY240 has no command %q", ErrInvalidCommand, name)
+}
+
+// Snapshot reports the detection zone
+func (xy240Driver) Snapshot(d *Device, info *DeviceInfo) {
+	info.Zone = d.Zone
+}
+
+// xrdr1Driver decodes the XRDR1 RFID Reader
+type xrdr1Driver struct{}
+
+// Match returns true for the RFID reader
+func (xrdr1Driver) Match(deviceType string) bool {
+	return deviceType == "XRDR1"
+}
+
+// Init requests the antenna status so the tags already on it are reported
+func (xrdr1Driver) Init(address int) []Command {
+	return []Command{XTalkCommand{Address: address, Format: "B"}}
+}
+
+// Decode tracks the tags on the antenna, a pickup or putback is reported by an XR frame
+// carrying the tag number followed by an antenna frame
+func (xrdr1Driver) Decode(d *Device, fb Frame, tag *Frame) []Event {
+	if d.Tags == nil {
+		d.Tags = make(map[int]bool)
+	}
+
+	event := Event{
+		Type: TypeRFIDAntenna,
 	}
 
 	switch fb.Format {
 	case "A":
 		switch fb.Command {
 		case "1":
-			event.Action = "pickup"
-			if c.lastFB != nil {
-				event.Data = fmt.Sprintf("%03d", c.lastFB.Address)
+			event.Action = ActionPickup
+			if tag != nil {
+				event.Data = fmt.Sprintf("%03d", tag.Address)
+				delete(d.Tags, tag.Address)
 			}
 		case "0":
-			event.Action = "putback"
-			if c.lastFB != nil {
-				event.Data = fmt.Sprintf("%03d", c.lastFB.Address)
+			event.Action = ActionPutback
+			if tag != nil {
+				event.Data = fmt.Sprintf("%03d", tag.Address)
+				d.Tags[tag.Address] = true
 			}
 		default:
 			return nil
 		}
-		return event
+		return []Event{event}
 
 	case "B":
-		event.Action = "status"
+		event.Action = ActionStatus
+
+		// The status lists every tag on the antenna
+		d.Tags = make(map[int]bool)
 
 		// Send additional updates for each tag
+		var events []Event
 		tags := strings.Split(fb.Command, " ")
 		for _, tag := range tags {
 			add, _ := strconv.Atoi(strings.TrimPrefix(tag, "d"))
 			if add == 0 {
 				continue
 			}
+			d.Tags[add] = true
 
-			// Dispatch additional putback event for each tag
-			tagEvent := Event{
-				Type:       "rfid-antenna",
-				Controller: c.name,
-				Address:    fb.Address,
-				Action:     "putback",
-				Data:       fmt.Sprintf("%03d", add),
-				Raw:        fb.Raw,
-			}
-			c.service.dispatch(tagEvent)
+			// Raise additional putback event for each tag
+			events = append(events, Event{
+				Type:   TypeRFIDAntenna,
+				Action: ActionPutback,
+				Data:   fmt.Sprintf("%03d", add),
+			})
 		}
 
-		return event
+		return append(events, event)
 	}
 
 	return nil
 }
+
+// Encode has no device specific commands
+func (xrdr1Driver) Encode(address int, name string, args ...string) (Command, error) {
+	return nil, fmt.Errorf("%w: XRDR1 has no command %q", ErrInvalidCommand, name)
+}
+
+// Snapshot reports the tags on the antenna in ascending order
+func (xrdr1Driver) Snapshot(d *Device, info *DeviceInfo) {
+	info.Tags = make([]int, 0, len(d.Tags))
+	for tag := range d.Tags {
+		info.Tags = append(info.Tags, tag)
+	}
+	sort.Ints(info.Tags)
+}
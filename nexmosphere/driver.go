@@ -0,0 +1,98 @@
+package nexmosphere
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DeviceDriver implements the X-Talk protocol of a type of Nexmosphere device
+//
+// Drivers are looked up by the type code a device reports, e.g. "XTB4N6". The
+// controller calls them with its lock held, so they must not block or call
+// back into the Service
+type DeviceDriver interface {
+	// Match returns true if the driver handles a device type code
+	Match(deviceType string) bool
+
+	// Init returns the commands sent to a device when it is discovered, and again after a reconnect
+	Init(address int) []Command
+
+	// Decode updates the device state from an X-Talk feedback frame and returns the resulting events
+	// tag is the last XR tag frame received on the same controller, nil if none, antenna frames
+	// refer to the tag it reported. The Controller, Address, DeviceType and Raw fields of the
+	// events are filled in by the caller
+	Decode(d *Device, fb Frame, tag *Frame) []Event
+
+	// Encode builds a device specific command, e.g. Encode(1, "led", "2", "on")
+	// It returns an error wrapping ErrInvalidCommand for commands the device doesn't support
+	Encode(address int, name string, args ...string) (Command, error)
+}
+
+// DeviceSnapshotter is optionally implemented by a DeviceDriver to fill in the DeviceInfo
+// returned by GetDevice and GetDevices from the device state. The common fields are already
+// set. Without it DeviceInfo.State is d.State as is, so drivers keeping state behind a
+// pointer should implement it and copy the state
+type DeviceSnapshotter interface {
+	Snapshot(d *Device, info *DeviceInfo)
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = []DeviceDriver{xtb4n6Driver{}, xrdr1Driver{}, xy240Driver{}}
+)
+
+// RegisterDriver adds support for a type of device
+// Drivers registered later take precedence, so a built-in driver can be replaced
+func RegisterDriver(driver DeviceDriver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	drivers = append(drivers, driver)
+}
+
+// driverFor returns the driver for a device type, or nil if there is none
+func driverFor(deviceType string) DeviceDriver {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	for i := len(drivers) - 1; i >= 0; i-- {
+		if drivers[i].Match(deviceType) {
+			return drivers[i]
+		}
+	}
+	return nil
+}
+
+// SendDeviceCommand encodes a command with the driver of the device at an address and queues it
+// For example SendDeviceCommand(controller, 1, "led", "2", "on")
+func (s *Service) SendDeviceCommand(controllerName string, address int, name string, args ...string) error {
+	c, err := s.getController(controllerName)
+	if err != nil {
+		return err
+	}
+
+	if address < 1 || address >= 1000 {
+		return fmt.Errorf("invalid device address %d (must be 1-999)", address)
+	}
+
+	c.mu.Lock()
+	deviceType := ""
+	if d := c.devices[address]; d != nil {
+		deviceType = d.Type
+	}
+	c.mu.Unlock()
+
+	if deviceType == "" {
+		return fmt.Errorf("%w at %s address %d", ErrNoDevice, c.name, address)
+	}
+
+	driver := driverFor(deviceType)
+	if driver == nil {
+		return fmt.Errorf("no driver for %s at %s address %d", deviceType, c.name, address)
+	}
+
+	cmd, err := driver.Encode(address, name, args...)
+	if err != nil {
+		return err
+	}
+	return s.Send(controllerName, cmd)
+}
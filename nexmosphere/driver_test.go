@@ -0,0 +1,108 @@
+package nexmosphere_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+	"github.org/carrierlabs/go-nexmosphere/simulator"
+)
+
+// counterDriver counts the frames from a device, behind a pointer so it needs a snapshot
+type counterDriver struct{}
+
+func (counterDriver) Match(deviceType string) bool { return deviceType == "XC100" }
+
+func (counterDriver) Init(address int) []nexmosphere.Command { return nil }
+
+func (counterDriver) Decode(d *nexmosphere.Device, fb nexmosphere.Frame, tag *nexmosphere.Frame) []nexmosphere.Event {
+	count, ok := d.State.(*int)
+	if !ok {
+		count = new(int)
+		d.State = count
+	}
+	*count++
+	return []nexmosphere.Event{{Type: "count", Action: "update", Data: fb.Command}}
+}
+
+func (counterDriver) Encode(address int, name string, args ...string) (nexmosphere.Command, error) {
+	return nil, fmt.Errorf("%w: XC100 has no command %q", nexmosphere.ErrInvalidCommand, name)
+}
+
+func (counterDriver) Snapshot(d *nexmosphere.Device, info *nexmosphere.DeviceInfo) {
+	if count, ok := d.State.(*int); ok {
+		info.State = *count
+	}
+}
+
+// lastDriver keeps the last command received as a value, without a snapshot
+type lastDriver struct{}
+
+func (lastDriver) Match(deviceType string) bool { return deviceType == "XC200" }
+
+func (lastDriver) Init(address int) []nexmosphere.Command { return nil }
+
+func (lastDriver) Decode(d *nexmosphere.Device, fb nexmosphere.Frame, tag *nexmosphere.Frame) []nexmosphere.Event {
+	d.State = fb.Command
+	return []nexmosphere.Event{{Type: "last", Action: "update", Data: fb.Command}}
+}
+
+func (lastDriver) Encode(address int, name string, args ...string) (nexmosphere.Command, error) {
+	return nil, fmt.Errorf("%w: XC200 has no command %q", nexmosphere.ErrInvalidCommand, name)
+}
+
+func init() {
+	nexmosphere.RegisterDriver(counterDriver{})
+	nexmosphere.RegisterDriver(lastDriver{})
+}
+
+// TestDriverState checks state kept by a registered driver is part of the device inventory
+func TestDriverState(t *testing.T) {
+	s := newTestService(t, nexmosphere.WithDeviceAddresses(1, 2))
+	events, cancel := s.Subscribe(nexmosphere.Filter{}, nexmosphere.WithBufferSize(256))
+	defer cancel()
+
+	sim := simulator.New("sim",
+		simulator.WithDevice(1, "XC100", "C1-0001"),
+		simulator.WithDevice(2, "XC200", "C2-0001"),
+	)
+	if err := s.AddController(sim); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReady}, 5*time.Second)
+
+	sim.Emit("X001A[1]")
+	sim.Emit("X001A[2]")
+	waitForEvent(t, events, nexmosphere.Filter{Type: "count"}, 5*time.Second)
+	waitForEvent(t, events, nexmosphere.Filter{Type: "count"}, 5*time.Second)
+	sim.Emit("X002A[7]")
+	waitForEvent(t, events, nexmosphere.Filter{Type: "last"}, 5*time.Second)
+
+	// The snapshotter copies the count out from behind its pointer
+	d, err := s.GetDevice("sim", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.State != 2 {
+		t.Errorf("XC100 State = %#v, want 2", d.State)
+	}
+
+	// Without a snapshotter the state is reported as is
+	d, err = s.GetDevice("sim", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.State != "7" {
+		t.Errorf("XC200 State = %#v, want \"7\"", d.State)
+	}
+
+	// GetDevices reports the same state
+	devices, err := s.GetDevices("sim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 || devices[0].State != 2 || devices[1].State != "7" {
+		t.Errorf("GetDevices() = %+v", devices)
+	}
+}
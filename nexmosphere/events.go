@@ -2,12 +2,81 @@ package nexmosphere
 
 import "time"
 
+// EventType is the kind of event, it selects the meaning of Address, Action and Data
+type EventType string
+
+// Event types
+const (
+	TypeController  EventType = "controller"   // Controller status, Address is 0
+	TypeDevice      EventType = "device"       // Device discovery and diagnostic replies, Data is "KEY=VALUE"
+	TypeButton      EventType = "button"       // XTB4N6 buttons, Data is the button number, e.g. "02"
+	TypeRFIDTag     EventType = "rfid-tag"     // RFID tag feedback, Address is the tag number
+	TypeRFIDAntenna EventType = "rfid-antenna" // XRDR1 antenna feedback, Data is the tag number, e.g. "007"
+	TypePresence    EventType = "presence"     // XY240 presence sensor, Data is the zone, e.g. "02"
+)
+
+// EventAction is what happened, the actions of each event type are listed by Vocabulary
+type EventAction string
+
+// Controller actions
+const (
+	ActionSystemUpdate EventAction = "system-update" // Controller added or removed, Data is "controllers=N,handlers=N"
+	ActionReady        EventAction = "ready"         // Device enumeration complete
+	ActionRejected     EventAction = "rejected"      // Port failed the controller handshake, Data is the reason
+	ActionDisconnected EventAction = "disconnected"  // Connection lost, Data is the reason
+	ActionReconnected  EventAction = "reconnected"   // Connection restored, Data is "attempt N"
+	ActionParseError   EventAction = "parse-error"   // Malformed feedback, Raw is the line
+)
+
+// Device actions
+const (
+	ActionUpdate   EventAction = "update"   // Diagnostic reply or device listing
+	ActionAttached EventAction = "attached" // Device connected after the controller was ready
+	ActionDetached EventAction = "detached" // Device removed or replaced
+)
+
+// Button actions
+const (
+	ActionClosed  EventAction = "closed"  // Switch closed
+	ActionOpen    EventAction = "open"    // Switch opened, Duration is how long it was closed
+	ActionPress   EventAction = "press"   // Logical press
+	ActionRelease EventAction = "release" // Logical release, Duration is how long it was held
+	ActionHold    EventAction = "hold"    // Button still held, repeated every hold interval
+	ActionChord   EventAction = "chord"   // Several buttons held together, Data lists them, e.g. "01+03"
+)
+
+// RFID actions
+const (
+	ActionPickup  EventAction = "pickup"  // Tag lifted from an antenna
+	ActionPutback EventAction = "putback" // Tag placed on an antenna
+	ActionStatus  EventAction = "status"  // Antenna status, followed by a putback for each tag on it
+	ActionUnknown EventAction = "unknown" // Unrecognised tag feedback
+)
+
+// Presence actions
+const (
+	ActionDetectionZone EventAction = "detection-zone" // Person detected in a zone
+)
+
+// Vocabulary returns every event type the library emits with the actions it is emitted with
+func Vocabulary() map[EventType][]EventAction {
+	return map[EventType][]EventAction{
+		TypeController:  {ActionSystemUpdate, ActionReady, ActionRejected, ActionDisconnected, ActionReconnected, ActionParseError},
+		TypeDevice:      {ActionUpdate, ActionAttached, ActionDetached},
+		TypeButton:      {ActionClosed, ActionOpen, ActionPress, ActionRelease, ActionHold, ActionChord},
+		TypeRFIDTag:     {ActionPickup, ActionPutback, ActionUnknown},
+		TypeRFIDAntenna: {ActionPickup, ActionPutback, ActionStatus},
+		TypePresence:    {ActionDetectionZone},
+	}
+}
+
 // Event represents a device event from a Nexmosphere controller
 type Event struct {
-	Type       string        `json:"type"`
+	Type       EventType     `json:"type"`
 	Controller string        `json:"controller"`
 	Address    int           `json:"address"`
-	Action     string        `json:"action"`
+	DeviceType string        `json:"deviceType,omitempty"` // Type of the device at Address, if known
+	Action     EventAction   `json:"action"`
 	Data       string        `json:"data,omitempty"`
 	Raw        string        `json:"raw,omitempty"`
 	Duration   time.Duration `json:"duration,omitempty"`
@@ -0,0 +1,165 @@
+package nexmosphere
+
+import (
+	"io"
+	"sync"
+)
+
+// HandlerID identifies a handler registered with AddHandler
+type HandlerID uint64
+
+// DeliveryMode controls how events are delivered to handlers
+type DeliveryMode int
+
+const (
+	// Concurrent handles every event on its own goroutine, a handler may receive events out of order
+	Concurrent DeliveryMode = iota
+	// Ordered queues events per handler and handles them one at a time, in the order they were dispatched
+	// A slow handler doesn't hold up the service or other handlers, its queue grows instead
+	Ordered
+)
+
+// WithDeliveryMode sets how events are delivered to handlers (default: Concurrent)
+func WithDeliveryMode(mode DeliveryMode) Option {
+	return func(s *Service) {
+		s.deliveryMode = mode
+	}
+}
+
+// registeredHandler is an event handler and, for ordered delivery, its queue of pending events
+type registeredHandler struct {
+	id      HandlerID
+	handler EventHandler
+	mu      sync.Mutex // Guards pending, running, active and removed
+	idle    *sync.Cond // Broadcast when active drops to zero
+	pending []Event
+	running bool // True while a goroutine is draining pending
+	active  int  // Number of handler calls in progress
+	removed bool
+}
+
+// newRegisteredHandler wraps a handler for delivery
+func newRegisteredHandler(id HandlerID, handler EventHandler) *registeredHandler {
+	h := &registeredHandler{id: id, handler: handler}
+	h.idle = sync.NewCond(&h.mu)
+	return h
+}
+
+// deliver passes an event to the handler using the service delivery mode
+func (h *registeredHandler) deliver(s *Service, event Event) {
+	if s.deliveryMode != Ordered {
+		s.spawn(func() { // Non-blocking dispatch
+			if h.begin() {
+				h.handle(s, event)
+				h.end()
+			}
+		})
+		return
+	}
+
+	h.mu.Lock()
+	if h.removed {
+		h.mu.Unlock()
+		return
+	}
+	h.pending = append(h.pending, event)
+	running := h.running
+	h.running = true
+	h.mu.Unlock()
+
+	// Start a worker if the handler is idle, it exits once the queue is empty
+	if !running {
+		s.spawn(func() { h.drain(s) })
+	}
+}
+
+// drain handles queued events in order until none are left
+func (h *registeredHandler) drain(s *Service) {
+	for {
+		h.mu.Lock()
+		if len(h.pending) == 0 || h.removed {
+			h.pending = nil
+			h.running = false
+			h.mu.Unlock()
+			return
+		}
+		event := h.pending[0]
+		h.pending = h.pending[1:]
+		h.active++
+		h.mu.Unlock()
+
+		h.handle(s, event)
+		h.end()
+	}
+}
+
+// handle calls the handler, a panic is logged rather than crashing the service
+func (h *registeredHandler) handle(s *Service, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Errorf("Event handler %d panicked on %s/%s event: %v", h.id, event.Type, event.Action, r)
+		}
+	}()
+
+	h.handler.HandleEvent(event)
+}
+
+// closer returns true if the handler implements io.Closer
+func (h *registeredHandler) closer() bool {
+	_, ok := h.handler.(io.Closer)
+	return ok
+}
+
+// close closes the handler if it implements io.Closer, a panic is logged rather than crashing the service
+func (h *registeredHandler) close(s *Service) {
+	closer, ok := h.handler.(io.Closer)
+	if !ok {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Errorf("Event handler %d panicked on close: %v", h.id, r)
+		}
+	}()
+
+	if err := closer.Close(); err != nil {
+		s.logger.Errorf("Failed to close event handler %d: %s", h.id, err)
+	}
+}
+
+// begin marks a handler call as in progress, it returns false once the handler has been removed
+// Checked under the same lock as remove, so no call starts after remove has returned
+func (h *registeredHandler) begin() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.removed {
+		return false
+	}
+	h.active++
+	return true
+}
+
+// end marks a handler call as finished
+func (h *registeredHandler) end() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.active--
+	if h.active == 0 {
+		h.idle.Broadcast()
+	}
+}
+
+// remove stops delivery to the handler, events still queued are dropped
+// It blocks until the calls in progress have returned
+func (h *registeredHandler) remove() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.removed = true
+	for h.active > 0 {
+		h.idle.Wait()
+	}
+}
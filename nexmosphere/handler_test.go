@@ -0,0 +1,169 @@
+package nexmosphere_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+	"github.org/carrierlabs/go-nexmosphere/simulator"
+)
+
+// TestRemoveHandler checks a handler isn't called once RemoveHandler returns, while events keep arriving
+func TestRemoveHandler(t *testing.T) {
+	for name, mode := range map[string]nexmosphere.DeliveryMode{"concurrent": nexmosphere.Concurrent, "ordered": nexmosphere.Ordered} {
+		mode := mode
+		t.Run(name, func(t *testing.T) {
+			s := newTestService(t, nexmosphere.WithDeviceAddresses(1), nexmosphere.WithDeliveryMode(mode))
+
+			var calls, removed int32
+			var late int32
+			id := s.AddHandler(nexmosphere.EventHandlerFunc(func(e nexmosphere.Event) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(time.Millisecond)
+
+				// Calls in progress are waited for, so none may still be running
+				if atomic.LoadInt32(&removed) == 1 {
+					atomic.AddInt32(&late, 1)
+				}
+			}))
+
+			sim := simulator.New("sim", simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+			if err := s.AddController(sim); err != nil {
+				t.Fatal(err)
+			}
+
+			// Parse errors are raised for every line, keep them coming while the handler is removed
+			done := make(chan struct{})
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				for {
+					select {
+					case <-done:
+						return
+					default:
+						sim.Emit("garbage")
+						time.Sleep(100 * time.Microsecond)
+					}
+				}
+			}()
+			defer func() {
+				close(done)
+				<-stopped
+			}()
+
+			for atomic.LoadInt32(&calls) < 20 {
+				time.Sleep(time.Millisecond)
+			}
+
+			if err := s.RemoveHandler(id); err != nil {
+				t.Fatal(err)
+			}
+			atomic.StoreInt32(&removed, 1)
+
+			time.Sleep(100 * time.Millisecond)
+			if n := atomic.LoadInt32(&late); n != 0 {
+				t.Errorf("handler running %d time(s) after RemoveHandler returned", n)
+			}
+
+			if err := s.RemoveHandler(id); err == nil {
+				t.Error("removing a handler twice succeeded")
+			}
+		})
+	}
+}
+
+// TestRemoveHandlerFromHandler checks a handler can remove itself from a goroutine, which
+// RemoveHandler returns in once the handler call has finished
+func TestRemoveHandlerFromHandler(t *testing.T) {
+	s := newTestService(t, nexmosphere.WithDeviceAddresses(1))
+
+	var id nexmosphere.HandlerID
+	removed := make(chan error, 1)
+	var once int32
+	id = s.AddHandler(nexmosphere.EventHandlerFunc(func(e nexmosphere.Event) {
+		if atomic.CompareAndSwapInt32(&once, 0, 1) {
+			go func() { removed <- s.RemoveHandler(id) }()
+		}
+	}))
+
+	sim := simulator.New("sim", simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+	if err := s.AddController(sim); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-removed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for RemoveHandler called from a handler")
+	}
+}
+
+// closingHandler counts the events it handles and the times it is closed
+type closingHandler struct {
+	events int32
+	closed int32
+}
+
+func (h *closingHandler) HandleEvent(e nexmosphere.Event) {
+	atomic.AddInt32(&h.events, 1)
+}
+
+func (h *closingHandler) Close() error {
+	atomic.AddInt32(&h.closed, 1)
+	return nil
+}
+
+// TestStopUnregistersClosedHandlers checks a handler closed by Stop isn't called after a restart
+func TestStopUnregistersClosedHandlers(t *testing.T) {
+	s := newStoppedService()
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	closing := &closingHandler{}
+	closingID := s.AddHandler(closing)
+	var plain int32
+	s.AddHandler(nexmosphere.EventHandlerFunc(func(e nexmosphere.Event) {
+		atomic.AddInt32(&plain, 1)
+	}))
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&closing.closed); n != 1 {
+		t.Fatalf("handler closed %d time(s), want 1", n)
+	}
+	if err := s.RemoveHandler(closingID); err == nil {
+		t.Error("closed handler is still registered")
+	}
+
+	// After a restart only the handler that wasn't closed receives events
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	events, cancel := s.Subscribe(nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReady})
+	defer cancel()
+	sim := simulator.New("sim", simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+	if err := s.AddController(sim); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, nexmosphere.Filter{}, 5*time.Second)
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&closing.events); n != 0 {
+		t.Errorf("closed handler received %d event(s) after a restart", n)
+	}
+	if atomic.LoadInt32(&plain) == 0 {
+		t.Error("handler without Close received no events after a restart")
+	}
+	if n := atomic.LoadInt32(&closing.closed); n != 1 {
+		t.Errorf("handler closed %d time(s), want 1", n)
+	}
+}
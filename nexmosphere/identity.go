@@ -0,0 +1,84 @@
+package nexmosphere
+
+import (
+	"fmt"
+)
+
+// usbIDPrefix marks controller IDs derived from a USB serial number
+const usbIDPrefix = "usb:"
+
+// controllerID returns the stable identity of the controller behind a transport
+// USB adapters that report a serial number are identified by it, so the ID survives
+// the port being renumbered after a reboot or replug. Anything else falls back to the port name
+func controllerID(info TransportInfo) string {
+	if info.IsUSB && info.SerialNumber != "" {
+		return usbIDPrefix + info.SerialNumber
+	}
+	return info.Name
+}
+
+// WithControllerAlias names a controller, e.g. "front-window"
+// The controller is matched by ID or port name, and the alias is then used in
+// events and accepted wherever a controller name is expected
+func WithControllerAlias(controller string, alias string) Option {
+	return func(s *Service) {
+		s.aliases[controller] = alias
+	}
+}
+
+// aliasFor returns the configured alias for a controller, or "" if it has none
+func (s *Service) aliasFor(c *Controller) string {
+	if alias, ok := s.aliases[c.id]; ok {
+		return alias
+	}
+	if alias, ok := s.aliases[c.portName()]; ok {
+		return alias
+	}
+	return ""
+}
+
+// eventName returns the name events of a controller carry, given its alias, ID or port name
+// Names that don't refer to an aliased controller are returned as is
+func (s *Service) eventName(controllerName string) string {
+	if controllerName == "" {
+		return ""
+	}
+	if alias, ok := s.aliases[controllerName]; ok {
+		return alias
+	}
+	if c, err := s.getController(controllerName); err == nil {
+		return c.name
+	}
+	return controllerName
+}
+
+// getController returns a controller by ID, alias or current port name
+func (s *Service) getController(controllerName string) (*Controller, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if c, ok := s.controllers[controllerName]; ok {
+		return c, nil
+	}
+	for _, c := range s.controllers {
+		if c.name == controllerName || c.portName() == controllerName {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("controller %s not found", controllerName)
+}
+
+// portInUse returns true if a port is owned by a controller, either open now or about
+// to be reclaimed by a controller with the same ID that is reconnecting
+// Must be called with s.mu held
+func (s *Service) portInUse(name string, id string) bool {
+	if c, ok := s.controllers[id]; ok && !c.isConnected() {
+		return true
+	}
+	for _, c := range s.controllers {
+		if c.portName() == name {
+			return true
+		}
+	}
+	return false
+}
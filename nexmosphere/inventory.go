@@ -0,0 +1,97 @@
+package nexmosphere
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoDevice is returned when no device is known at an address
+var ErrNoDevice = errors.New("no device")
+
+// DeviceInfo is a snapshot of a device connected to a controller
+// It is a copy, later feedback from the device doesn't change it
+type DeviceInfo struct {
+	Controller  string        // Controller alias or ID
+	Address     int           // X-Talk address (1-999)
+	Type        string        // Product code, e.g. "XTB4N6"
+	Serial      string        // Serial number, if reported
+	Firmware    string        // Firmware version, if reported
+	LastSeen    time.Time     // Time of the last feedback from the device
+	Buttons     []ButtonState // Button states, XTB4N6 only
+	Tags        []int         // Tags on the antenna in ascending order, XRDR1 only
+	Zone        int           // Detection zone, XY240 only
+	LEDFeedback bool          // LED feedback flag, XTB4N6 only
+	State       interface{}   // Driver specific state, see DeviceSnapshotter
+}
+
+// ButtonState is a snapshot of a button on a device
+type ButtonState struct {
+	Button    int       // Button number (1-4)
+	Closed    bool      // Physical button state (wire closed)
+	Pressed   bool      // Logical button state (debounced)
+	PressedAt time.Time // Time the button was pressed, zero if it isn't
+	LED       LEDMode   // Last mode set with SetButtonLED
+}
+
+// GetDevices returns the devices known on a controller, ordered by address
+func (s *Service) GetDevices(controllerName string) ([]DeviceInfo, error) {
+	c, err := s.getController(controllerName)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	devices := make([]DeviceInfo, 0)
+	for i, d := range c.devices {
+		if i > 0 && d != nil && d.Type != "" {
+			devices = append(devices, d.info(c.name, i))
+		}
+	}
+	return devices, nil
+}
+
+// GetDevice returns the device at an address on a controller
+// It returns an error wrapping ErrNoDevice if no device is known there
+func (s *Service) GetDevice(controllerName string, address int) (DeviceInfo, error) {
+	c, err := s.getController(controllerName)
+	if err != nil {
+		return DeviceInfo{}, err
+	}
+
+	if address < 1 || address >= 1000 {
+		return DeviceInfo{}, fmt.Errorf("invalid device address %d (must be 1-999)", address)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := c.devices[address]
+	if d == nil || d.Type == "" {
+		return DeviceInfo{}, fmt.Errorf("%w at %s address %d", ErrNoDevice, c.name, address)
+	}
+	return d.info(c.name, address), nil
+}
+
+// info returns a snapshot of the device, must be called with c.mu held
+func (d *Device) info(controllerName string, address int) DeviceInfo {
+	info := DeviceInfo{
+		Controller: controllerName,
+		Address:    address,
+		Type:       d.Type,
+		Serial:     d.Serial,
+		Firmware:   d.Firmware,
+		LastSeen:   d.LastSeen,
+	}
+
+	// The driver knows which state its device keeps
+	if snapshotter, ok := driverFor(d.Type).(DeviceSnapshotter); ok {
+		snapshotter.Snapshot(d, &info)
+	} else {
+		info.State = d.State
+	}
+
+	return info
+}
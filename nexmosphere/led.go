@@ -0,0 +1,163 @@
+package nexmosphere
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LEDMode is the pattern shown by a button LED
+type LEDMode int
+
+// LED modes
+const (
+	LEDOff   LEDMode = iota // LED off
+	LEDOn                   // LED continuously on
+	LEDBlink                // LED blinking
+	LEDPulse                // LED fading in and out
+)
+
+// ledModeNames are the X-Talk names of the LED modes
+var ledModeNames = map[LEDMode]string{
+	LEDOff:   "OFF",
+	LEDOn:    "ON",
+	LEDBlink: "BLINK",
+	LEDPulse: "PULSE",
+}
+
+// String returns the X-Talk name of the mode, e.g. "BLINK"
+func (m LEDMode) String() string {
+	if name, ok := ledModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("LEDMode(%d)", int(m))
+}
+
+// ParseLEDMode returns the mode with a given name, e.g. "blink", ignoring case
+func ParseLEDMode(name string) (LEDMode, error) {
+	for mode, n := range ledModeNames {
+		if strings.EqualFold(name, n) {
+			return mode, nil
+		}
+	}
+	return LEDOff, fmt.Errorf("%w: unknown LED mode %q", ErrInvalidCommand, name)
+}
+
+// ledCommand returns the command setting the LED of an XTB4N6 button
+//
+//	ledCommand(1, 2, LEDBlink)  ->  X001B[LED2=BLINK]
+func ledCommand(address int, button int, mode LEDMode) (Command, error) {
+	if button < 1 || button > buttonCount {
+		return nil, fmt.Errorf("%w: invalid button %d (must be 1-%d)", ErrInvalidCommand, button, buttonCount)
+	}
+	if _, ok := ledModeNames[mode]; !ok {
+		return nil, fmt.Errorf("%w: invalid LED mode %d", ErrInvalidCommand, int(mode))
+	}
+
+	return XTalkCommand{
+		Address: address,
+		Format:  "B",
+		Data:    fmt.Sprintf("LED%d=%s", button, mode),
+	}, nil
+}
+
+// encodeLED decodes the arguments of a "led" device command, e.g. "2", "blink"
+func encodeLED(address int, args []string) (Command, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("%w: led takes a button and a mode, got %d argument(s)", ErrInvalidCommand, len(args))
+	}
+
+	button, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid button %q", ErrInvalidCommand, args[0])
+	}
+
+	mode, err := ParseLEDMode(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return ledCommand(address, button, mode)
+}
+
+// SetButtonLED sets the LED of a button (1-4), e.g. on an XTB4N6 button interface
+// The command is encoded by the driver of the device as "led". The mode is recorded in the
+// device model once the command has been written, see ButtonState.LED, and restored after a reconnect
+func (s *Service) SetButtonLED(controllerName string, address int, button int, mode LEDMode) error {
+	c, err := s.getController(controllerName)
+	if err != nil {
+		return err
+	}
+
+	if address < 1 || address >= 1000 {
+		return fmt.Errorf("invalid device address %d (must be 1-999)", address)
+	}
+
+	if button < 1 || button > buttonCount {
+		return fmt.Errorf("%w: invalid button %d (must be 1-%d)", ErrInvalidCommand, button, buttonCount)
+	}
+
+	c.mu.Lock()
+	d := c.devices[address]
+	if d == nil || d.Type == "" {
+		c.mu.Unlock()
+		return fmt.Errorf("%w at %s address %d", ErrNoDevice, c.name, address)
+	}
+	deviceType := d.Type
+
+	driver := driverFor(deviceType)
+	if driver == nil {
+		c.mu.Unlock()
+		return fmt.Errorf("no driver for %s at %s address %d", deviceType, c.name, address)
+	}
+
+	cmd, err := driver.Encode(address, "led", strconv.Itoa(button), mode.String())
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+
+	// Queued under the lock so sequence numbers follow the order commands are written in
+	b := &d.Button[button-1]
+	b.ledQueued++
+	seq := b.ledQueued
+	done := make(chan error, 1)
+	if err := c.enqueue(commandQueue, cmd, done); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.mu.Unlock()
+
+	s.spawn(func() {
+		if err := <-done; err != nil {
+			s.logger.Debugf("LED %d of %s device %d not set: %s", button, controllerName, address, err)
+			return
+		}
+
+		// Skip modes overtaken by a later command, or queued before the device was reset
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if d.Type == deviceType && seq <= b.ledQueued && seq > b.ledWritten {
+			b.ledWritten = seq
+			b.LED = mode
+		}
+	})
+
+	s.logger.Debugf("Set LED %d of %s device %d to %s", button, controllerName, address, mode)
+	return nil
+}
+
+// ledCommands returns the commands restoring the LEDs that aren't off, encoded by the driver of the device
+// Must be called with c.mu held
+func (d *Device) ledCommands(driver DeviceDriver, address int) []Command {
+	cmds := make([]Command, 0)
+	for i, b := range d.Button {
+		if b.LED == LEDOff {
+			continue
+		}
+		if cmd, err := driver.Encode(address, "led", strconv.Itoa(i+1), b.LED.String()); err == nil {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
@@ -0,0 +1,140 @@
+package nexmosphere_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+	"github.org/carrierlabs/go-nexmosphere/simulator"
+)
+
+// lampDriver controls the LEDs of a device with its own command format
+type lampDriver struct{}
+
+func (lampDriver) Match(deviceType string) bool { return deviceType == "XL100" }
+
+func (lampDriver) Init(address int) []nexmosphere.Command { return nil }
+
+func (lampDriver) Decode(d *nexmosphere.Device, fb nexmosphere.Frame, tag *nexmosphere.Frame) []nexmosphere.Event {
+	return nil
+}
+
+func (lampDriver) Encode(address int, name string, args ...string) (nexmosphere.Command, error) {
+	if name != "led" || len(args) != 2 {
+		return nil, fmt.Errorf("%w: XL100 has no command %q", nexmosphere.ErrInvalidCommand, name)
+	}
+	return nexmosphere.XTalkCommand{Address: address, Format: "B", Data: "L" + args[0] + ":" + args[1]}, nil
+}
+
+func init() {
+	nexmosphere.RegisterDriver(lampDriver{})
+}
+
+// buttonLED returns the LED mode recorded for a button
+func buttonLED(t *testing.T, s *nexmosphere.Service, controller string, address int, button int) nexmosphere.LEDMode {
+	t.Helper()
+
+	d, err := s.GetDevice(controller, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Buttons) < button {
+		return nexmosphere.LEDOff
+	}
+	return d.Buttons[button-1].LED
+}
+
+func TestSetButtonLEDDriver(t *testing.T) {
+	s := newTestService(t, nexmosphere.WithDeviceAddresses(1, 2, 3))
+	events, cancel := s.Subscribe(nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReady})
+	defer cancel()
+
+	sim := simulator.New("sim",
+		simulator.WithDevice(1, "XTB4N6", "B4-0001"),
+		simulator.WithDevice(2, "XL100", "L1-0001"),
+		simulator.WithDevice(3, "XY240", "XY-0001"),
+	)
+	if err := s.AddController(sim); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, nexmosphere.Filter{}, 5*time.Second)
+
+	// The built-in driver and a registered driver each encode their own command
+	if err := s.SetButtonLED("sim", 1, 2, nexmosphere.LEDBlink); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetButtonLED("sim", 2, 3, nexmosphere.LEDOn); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range []string{"X001B[LED2=BLINK]", "X002B[L3:ON]"} {
+		if !sim.WaitForCommand(cmd, 5*time.Second) {
+			t.Errorf("%s not written, commands %v", cmd, sim.Commands())
+		}
+	}
+
+	// Devices without LEDs and bad arguments are refused
+	tests := []struct {
+		name    string
+		address int
+		button  int
+		mode    nexmosphere.LEDMode
+		want    error
+	}{
+		{"no led command", 3, 1, nexmosphere.LEDOn, nexmosphere.ErrInvalidCommand},
+		{"no device", 4, 1, nexmosphere.LEDOn, nexmosphere.ErrNoDevice},
+		{"button 0", 1, 0, nexmosphere.LEDOn, nexmosphere.ErrInvalidCommand},
+		{"button 5", 1, 5, nexmosphere.LEDOn, nexmosphere.ErrInvalidCommand},
+		{"invalid mode", 1, 1, nexmosphere.LEDMode(9), nexmosphere.ErrInvalidCommand},
+	}
+	for _, tt := range tests {
+		if err := s.SetButtonLED("sim", tt.address, tt.button, tt.mode); !errors.Is(err, tt.want) {
+			t.Errorf("%s: SetButtonLED() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+// TestSetButtonLEDRecordedOnWrite checks the mode is recorded once the command is written, not when queued
+func TestSetButtonLEDRecordedOnWrite(t *testing.T) {
+	addr, sims := newTCPBridge(t, simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+	name := "tcp://" + addr
+
+	s := newTestService(t,
+		nexmosphere.WithDeviceAddresses(1),
+		nexmosphere.WithReconnectPolicy(nexmosphere.ReconnectPolicy{InitialDelay: 500 * time.Millisecond, MaxDelay: time.Second, Multiplier: 2}),
+	)
+	events, cancel := s.Subscribe(nexmosphere.Filter{Controller: name}, nexmosphere.WithBufferSize(256))
+	defer cancel()
+
+	if err := s.AddTCPController(addr); err != nil {
+		t.Fatal(err)
+	}
+	sim := receiveSim(t, sims)
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReady}, 5*time.Second)
+
+	// Commands are held while disconnected
+	sim.Close()
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionDisconnected}, 5*time.Second)
+
+	if err := s.SetButtonLED(name, 1, 2, nexmosphere.LEDPulse); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if led := buttonLED(t, s, name, 1, 2); led != nexmosphere.LEDOff {
+		t.Errorf("LED recorded as %s before the command was written", led)
+	}
+
+	sim = receiveSim(t, sims)
+	if !sim.WaitForCommand("X001B[LED2=PULSE]", 5*time.Second) {
+		t.Fatalf("LED command not written after reconnect, commands %v", sim.Commands())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for buttonLED(t, s, name, 1, 2) != nexmosphere.LEDPulse {
+		if time.Now().After(deadline) {
+			t.Fatal("LED not recorded after the command was written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,249 @@
+package nexmosphere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// readyTimeout is how long devices have to answer after the last type query is sent
+const readyTimeout = 5 * time.Second
+
+// ErrNotNexmosphere is returned when a port fails the controller handshake
+var ErrNotNexmosphere = errors.New("not a Nexmosphere controller")
+
+// ErrNotRunning is returned when a controller is added to a service that hasn't been started
+var ErrNotRunning = errors.New("service not running")
+
+// runningContext returns the context of the running service
+func (s *Service) runningContext() (context.Context, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.run == nil || s.run.stopping {
+		return nil, ErrNotRunning
+	}
+	return s.run.ctx, nil
+}
+
+// AddController attaches a controller connected over a custom transport
+// It blocks for the settle delay and handshake, devices are then queried in the
+// background and a "ready" event is dispatched once they have answered. The
+// service must be running. On error the transport is closed
+func (s *Service) AddController(t Transport) error {
+	ctx, err := s.runningContext()
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.setupController(ctx, t, nil); err != nil {
+		t.Close()
+		return err
+	}
+	return nil
+}
+
+// setupController runs the initialisation pipeline for a newly opened transport:
+// settle delay, handshake, then device enumeration. If reopen is set the
+// controller is reconnected with it whenever the connection is lost
+func (s *Service) setupController(ctx context.Context, t Transport, reopen func() (Transport, error)) (*Controller, error) {
+	// Give the controller time to settle after the port is opened
+	if s.settleDelay > 0 {
+		select {
+		case <-time.After(s.settleDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	c, err := s.attachController(ctx, t, reopen)
+	if err != nil {
+		return nil, err
+	}
+
+	s.initController(ctx, c)
+	return c, nil
+}
+
+// attachController validates a transport is a Nexmosphere controller, registers it and starts listening to it
+func (s *Service) attachController(ctx context.Context, t Transport, reopen func() (Transport, error)) (*Controller, error) {
+	c := newController(s, t)
+	c.reopen = reopen
+
+	if err := s.checkControllerID(c); err != nil {
+		return nil, err
+	}
+
+	// Check the port really is a Nexmosphere controller before adopting it
+	if s.probeTimeout > 0 {
+		model, err := c.probe(ctx, s.probeTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			s.logger.Infof("Rejected %s: %s", c.name, err)
+			s.dispatch(Event{
+				Type:       TypeController,
+				Controller: c.name,
+				Action:     ActionRejected,
+				Data:       err.Error(),
+			})
+			return nil, fmt.Errorf("%w: %s", ErrNotNexmosphere, err)
+		}
+
+		c.md.productCode = model
+		s.logger.Infof("Found %s controller on %s", model, c.name)
+	}
+
+	if alias := s.aliasFor(c); alias != "" {
+		c.name = alias
+	}
+
+	s.mu.Lock()
+	if ctx.Err() != nil {
+		s.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	if _, exists := s.controllers[c.id]; exists {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("controller %s already exists", c.id)
+	}
+	s.controllers[c.id] = c
+	s.mu.Unlock()
+
+	s.sendSystemUpdate()
+
+	// Listen to port, reconnect or cleanup on close
+	s.logger.Infof("Listening: %v (%s)", c.name, c.portName())
+	s.spawn(func() { s.runController(ctx, c) })
+
+	return c, nil
+}
+
+// checkControllerID makes sure a new controller's ID isn't already taken
+// Adapters sharing a USB serial number fall back to being identified by port name
+func (s *Service) checkControllerID(c *Controller) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	existing, exists := s.controllers[c.id]
+	if !exists {
+		return nil
+	}
+
+	port := c.portName()
+	if c.id == port || existing.portName() == port {
+		return fmt.Errorf("controller %s already exists", c.id)
+	}
+
+	s.logger.Warnf("Controller on %s has the same serial number as %s, identifying it by port name", port, existing.portName())
+	c.id = port
+	c.name = port
+	if _, exists := s.controllers[c.id]; exists {
+		return fmt.Errorf("controller %s already exists", c.id)
+	}
+	return nil
+}
+
+// initController queries the devices attached to a controller and starts its command queue
+// Background work started for the controller stops when ctx is cancelled
+func (s *Service) initController(ctx context.Context, c *Controller) {
+	addresses := s.deviceAddresses(c)
+
+	// Send commands to get device information
+	c.mu.Lock()
+	c.pendingDevices = make(map[int]bool, len(addresses))
+	for _, i := range addresses {
+		c.pendingDevices[i] = true
+	}
+	c.mu.Unlock()
+	for _, i := range addresses {
+		s.logger.Debugf("Sending info request to address %d", i)
+		c.addToQueue(systemQueue, DiagnosticQuery{Address: i, Key: "TYPE"})
+	}
+
+	// Timeout for ready state if devices don't respond, allowing for the queries to be paced out
+	timeout := readyTimeout + time.Duration(len(addresses))*s.commandInterval
+	s.spawn(func() {
+		select {
+		case <-time.After(timeout):
+		case <-c.done:
+			return
+		}
+
+		c.mu.Lock()
+		defer c.unlock()
+
+		if !c.ready {
+			c.ready = true
+			devicesFound := len(addresses) - len(c.pendingDevices)
+			s.logger.Infof("Controller %s ready - %d device(s) found", c.name, devicesFound)
+			c.emit(Event{
+				Type:       TypeController,
+				Controller: c.name,
+				Action:     ActionReady,
+				Data:       fmt.Sprintf("%d device(s) found", devicesFound),
+			})
+		}
+	})
+
+	// Start command queue processor
+	s.spawn(func() { c.processQueue(s.commandInterval) })
+
+	// Periodically look for devices plugged in or removed
+	if s.rescanInterval > 0 {
+		s.spawn(func() { c.rescanLoop(ctx, s.rescanInterval) })
+	}
+}
+
+// deviceAddresses returns the addresses to probe on a controller
+// Addresses configured for the controller take precedence over its model, then the default
+func (s *Service) deviceAddresses(c *Controller) []int {
+	for _, name := range []string{c.name, c.id, c.portName()} {
+		if addresses, ok := s.controllerAddresses[name]; ok {
+			return addresses
+		}
+	}
+	if addresses, ok := s.modelAddresses[c.md.productCode]; ok && c.md.productCode != "" {
+		return addresses
+	}
+	return s.defaultAddresses
+}
+
+// superviseController keeps a controller connected, opening its transport whenever it isn't
+// Lost connections are first recovered by the reconnect policy, once that gives up
+// the transport is reopened from scratch. It returns when ctx is cancelled
+func (s *Service) superviseController(ctx context.Context, name string, open func() (Transport, error)) {
+	policy := s.reconnectPolicy
+	for attempt := 1; ; attempt++ {
+		t, err := open()
+		if err == nil {
+			var c *Controller
+			c, err = s.setupController(ctx, t, open)
+			if err == nil {
+				<-c.done
+				attempt = 0
+			} else {
+				t.Close()
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			s.logger.Debugf("Failed to open controller %s: %s", name, err)
+		}
+
+		delay := policy.delay(attempt)
+		s.logger.Infof("Reconnecting to %s in %s", name, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package nexmosphere
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrMalformedFrame is returned for feedback that is not a valid Nexmosphere frame
+var ErrMalformedFrame = errors.New("malformed frame")
+
+// maxFrameLength is the longest line accepted from a controller
+const maxFrameLength = 256
+
+// Frame is a single line of feedback from a controller
+//
+// Two shapes are recognised:
+//
+//	X001A[3]      Type "X", Address 1, Format "A", Command "3"
+//	XR[PU003]     Type "XR", Address 3 (tag number), Command "PU003"
+type Frame struct {
+	Type    string // Frame type: "X" (X-Talk), "D" (diagnostic), "XR" (RFID tag) etc.
+	Address int    // Device address (0-999), or tag number for XR frames
+	Format  string // Command format, e.g. "A" or "B" (empty for XR frames)
+	Command string // Content between the brackets, whitespace trimmed
+	Raw     string // Line as received
+}
+
+// ParseFrame decodes a line of controller feedback
+// It returns an error wrapping ErrMalformedFrame for any input that is not a well-formed frame
+func ParseFrame(line string) (Frame, error) {
+	fb := Frame{Raw: line}
+
+	data := strings.TrimRight(line, "\r\n")
+	if data == "" {
+		return fb, fmt.Errorf("%w: empty line", ErrMalformedFrame)
+	}
+	if len(data) > maxFrameLength {
+		return fb, fmt.Errorf("%w: length %d exceeds %d", ErrMalformedFrame, len(data), maxFrameLength)
+	}
+
+	// Split header and bracketed command
+	open := strings.IndexByte(data, '[')
+	if open < 0 || !strings.HasSuffix(data, "]") || strings.IndexByte(data[open+1:], ']') != len(data)-open-2 {
+		return fb, fmt.Errorf("%w: missing or misplaced brackets", ErrMalformedFrame)
+	}
+	header := data[:open]
+	fb.Command = strings.TrimSpace(data[open+1 : len(data)-1])
+
+	// XR Sensors (RFID tags), e.g. XR[PU003]
+	if header == "XR" {
+		if len(fb.Command) < 3 {
+			return fb, fmt.Errorf("%w: short XR command %q", ErrMalformedFrame, fb.Command)
+		}
+		address, err := parseAddress(fb.Command[2:])
+		if err != nil {
+			return fb, err
+		}
+		fb.Type = "XR"
+		fb.Address = address
+		return fb, nil
+	}
+
+	// Addressed commands, e.g. X001A[3]
+	if len(header) != 5 || !isUpper(header[0]) || !isUpper(header[4]) {
+		return fb, fmt.Errorf("%w: invalid header %q", ErrMalformedFrame, header)
+	}
+	address, err := parseAddress(header[1:4])
+	if err != nil {
+		return fb, err
+	}
+
+	fb.Type = header[0:1]
+	fb.Address = address
+	fb.Format = header[4:5]
+	return fb, nil
+}
+
+// parseAddress parses a 3 digit device address or tag number
+func parseAddress(s string) (int, error) {
+	if len(s) != 3 {
+		return 0, fmt.Errorf("%w: invalid address %q", ErrMalformedFrame, s)
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, fmt.Errorf("%w: invalid address %q", ErrMalformedFrame, s)
+		}
+	}
+	return strconv.Atoi(s)
+}
+
+// isUpper returns true for an ASCII upper case letter
+func isUpper(b byte) bool {
+	return b >= 'A' && b <= 'Z'
+}
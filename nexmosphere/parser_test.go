@@ -0,0 +1,102 @@
+package nexmosphere
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseFrame(t *testing.T) {
+	tests := []struct {
+		line string
+		want Frame
+	}{
+		{"X001A[3]", Frame{Type: "X", Address: 1, Format: "A", Command: "3"}},
+		{"X001A[3]\r\n", Frame{Type: "X", Address: 1, Format: "A", Command: "3"}},
+		{"X999B[ d003 d005 ]", Frame{Type: "X", Address: 999, Format: "B", Command: "d003 d005"}},
+		{"X003B[Dz=02]", Frame{Type: "X", Address: 3, Format: "B", Command: "Dz=02"}},
+		{"D000B[TYPE=XN-185]", Frame{Type: "D", Address: 0, Format: "B", Command: "TYPE=XN-185"}},
+		{"D001B[]", Frame{Type: "D", Address: 1, Format: "B", Command: ""}},
+		{"XR[PU003]", Frame{Type: "XR", Address: 3, Command: "PU003"}},
+		{"XR[PB120]", Frame{Type: "XR", Address: 120, Command: "PB120"}},
+	}
+
+	for _, tt := range tests {
+		fb, err := ParseFrame(tt.line)
+		if err != nil {
+			t.Errorf("ParseFrame(%q) error: %v", tt.line, err)
+			continue
+		}
+		tt.want.Raw = tt.line
+		if fb != tt.want {
+			t.Errorf("ParseFrame(%q) = %+v, want %+v", tt.line, fb, tt.want)
+		}
+	}
+}
+
+func TestParseFrameMalformed(t *testing.T) {
+	lines := []string{
+		"",
+		"\r\n",
+		"X001A",
+		"X001A3]",
+		"X001A[3",
+		"X001A[3]]",
+		"X001A[3]x",
+		"[3]",
+		"X01A[3]",
+		"X0001A[3]",
+		"x001A[3]",
+		"X001a[3]",
+		"X00AA[3]",
+		"X-01A[3]",
+		"XR[]",
+		"XR[PU]",
+		"XR[PU03]",
+		"XR[PU0003]",
+		"XR[PUabc]",
+		"\x00\xff[]",
+		"X001A[" + strings.Repeat("1", maxFrameLength) + "]",
+	}
+
+	for _, line := range lines {
+		if fb, err := ParseFrame(line); !errors.Is(err, ErrMalformedFrame) {
+			t.Errorf("ParseFrame(%q) = %+v, %v, want ErrMalformedFrame", line, fb, err)
+		}
+	}
+}
+
+func FuzzParseFrame(f *testing.F) {
+	seeds := []string{
+		// Well-formed
+		"X001A[3]", "XR[PU003]", "D001B[TYPE=XTB4N6]", "X002B[d003 d005]", "X003B[Dz=02]",
+		// Short lines
+		"", "[", "]", "[]", "X", "XR", "XR[", "XR[]", "XR[P]", "X001A[",
+		// Unbracketed lines
+		"X001A3", "TYPE=XTB4N6", "XRPU003",
+		// Line noise
+		"\x00\x00\x00", "\xff\xfe[\x80]", "X\x00\x00\x00A[3]", "]]][[[", "X001A[3]\r\nX002A[5]",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		fb, err := ParseFrame(line)
+		if fb.Raw != line {
+			t.Fatalf("Raw = %q, want %q", fb.Raw, line)
+		}
+		if err != nil {
+			if !errors.Is(err, ErrMalformedFrame) {
+				t.Fatalf("error %v doesn't wrap ErrMalformedFrame", err)
+			}
+			return
+		}
+		if fb.Address < 0 || fb.Address > 999 {
+			t.Fatalf("address %d out of range", fb.Address)
+		}
+		if fb.Type == "" {
+			t.Fatal("empty type")
+		}
+	})
+}
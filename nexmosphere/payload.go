@@ -0,0 +1,177 @@
+package nexmosphere
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ButtonEvent is the payload of a "button" event
+type ButtonEvent struct {
+	Button   int           // Button number (1-4), 0 for "chord"
+	Buttons  []int         // Buttons held together, for "chord"
+	Action   EventAction   // "press", "release", "hold", "closed", "open" or "chord"
+	Duration time.Duration // Time the button has been held, for "hold", "open" and "release"
+}
+
+// RFIDEvent is the payload of an "rfid-tag" or "rfid-antenna" event
+type RFIDEvent struct {
+	Tag     int         // Tag number, 0 for antenna "status" events
+	Antenna int         // Address of the antenna, 0 for "rfid-tag" events which don't identify it
+	Action  EventAction // "pickup", "putback" or, for antennas, "status"
+}
+
+// PresenceEvent is the payload of a "presence" event
+type PresenceEvent struct {
+	Zone   int         // Detection zone reported by the sensor
+	Action EventAction // "detection-zone"
+}
+
+// DeviceEvent is the payload of a "device" event
+type DeviceEvent struct {
+	Address    int         // Device address
+	DeviceType string      // Type of the device, e.g. "XTB4N6", if known
+	Key        string      // Diagnostic key reported, e.g. "TYPE" or "SERIAL"
+	Value      string      // Value reported for Key
+	Action     EventAction // "update", "attached" or "detached"
+}
+
+// ControllerStatusEvent is the payload of a "controller" event
+type ControllerStatusEvent struct {
+	Action      EventAction // e.g. "ready", "disconnected" or "system-update"
+	Controllers int         // Number of controllers, for "system-update"
+	Handlers    int         // Number of handlers, for "system-update"
+	Attempt     int         // Reconnect attempt that succeeded, for "reconnected"
+	Message     string      // Human readable detail, e.g. the error for "rejected" or "parse-error"
+}
+
+// Button returns the payload of a "button" event, ok is false for any other event
+func (e Event) Button() (payload ButtonEvent, ok bool) {
+	if e.Type != TypeButton {
+		return ButtonEvent{}, false
+	}
+
+	if e.Action == ActionChord {
+		payload = ButtonEvent{Action: e.Action}
+		for _, field := range strings.Split(e.Data, "+") {
+			button, err := strconv.Atoi(field)
+			if err != nil {
+				return ButtonEvent{}, false
+			}
+			payload.Buttons = append(payload.Buttons, button)
+		}
+		return payload, true
+	}
+
+	button, err := strconv.Atoi(e.Data)
+	if err != nil {
+		return ButtonEvent{}, false
+	}
+
+	return ButtonEvent{
+		Button:   button,
+		Action:   e.Action,
+		Duration: e.Duration,
+	}, true
+}
+
+// RFID returns the payload of an "rfid-tag" or "rfid-antenna" event, ok is false for any other event
+func (e Event) RFID() (payload RFIDEvent, ok bool) {
+	switch e.Type {
+	case TypeRFIDTag: // Address is the tag number
+		return RFIDEvent{
+			Tag:    e.Address,
+			Action: e.Action,
+		}, true
+
+	case TypeRFIDAntenna: // Address is the antenna, Data the tag number
+		payload = RFIDEvent{
+			Antenna: e.Address,
+			Action:  e.Action,
+		}
+		if e.Data != "" {
+			tag, err := strconv.Atoi(e.Data)
+			if err != nil {
+				return RFIDEvent{}, false
+			}
+			payload.Tag = tag
+		}
+		return payload, true
+	}
+
+	return RFIDEvent{}, false
+}
+
+// Presence returns the payload of a "presence" event, ok is false for any other event
+func (e Event) Presence() (payload PresenceEvent, ok bool) {
+	if e.Type != TypePresence {
+		return PresenceEvent{}, false
+	}
+
+	zone, err := strconv.Atoi(e.Data)
+	if err != nil {
+		return PresenceEvent{}, false
+	}
+
+	return PresenceEvent{
+		Zone:   zone,
+		Action: e.Action,
+	}, true
+}
+
+// Device returns the payload of a "device" event, ok is false for any other event
+func (e Event) Device() (payload DeviceEvent, ok bool) {
+	if e.Type != TypeDevice {
+		return DeviceEvent{}, false
+	}
+
+	payload = DeviceEvent{
+		Address:    e.Address,
+		DeviceType: e.DeviceType,
+		Action:     e.Action,
+	}
+
+	// Data is the diagnostic reply, e.g. "TYPE=XTB4N6"
+	if key, value, found := strings.Cut(e.Data, "="); found {
+		payload.Key = key
+		payload.Value = value
+		if key == "TYPE" && payload.DeviceType == "" {
+			payload.DeviceType = value
+		}
+	}
+
+	return payload, true
+}
+
+// ControllerStatus returns the payload of a "controller" event, ok is false for any other event
+func (e Event) ControllerStatus() (payload ControllerStatusEvent, ok bool) {
+	if e.Type != TypeController {
+		return ControllerStatusEvent{}, false
+	}
+
+	payload = ControllerStatusEvent{
+		Action: e.Action,
+	}
+
+	switch e.Action {
+	case ActionSystemUpdate: // Data is "controllers=1,handlers=2"
+		for _, field := range strings.Split(e.Data, ",") {
+			key, value, _ := strings.Cut(field, "=")
+			n, _ := strconv.Atoi(value)
+			switch key {
+			case "controllers":
+				payload.Controllers = n
+			case "handlers":
+				payload.Handlers = n
+			}
+		}
+
+	case ActionReconnected: // Data is "attempt 2"
+		payload.Attempt, _ = strconv.Atoi(strings.TrimPrefix(e.Data, "attempt "))
+
+	default:
+		payload.Message = e.Data
+	}
+
+	return payload, true
+}
@@ -0,0 +1,275 @@
+package nexmosphere_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+)
+
+func TestButtonPayload(t *testing.T) {
+	tests := []struct {
+		name  string
+		event nexmosphere.Event
+		want  nexmosphere.ButtonEvent
+		ok    bool
+	}{
+		{
+			name:  "press",
+			event: nexmosphere.Event{Type: nexmosphere.TypeButton, Action: nexmosphere.ActionPress, Data: "02"},
+			want:  nexmosphere.ButtonEvent{Button: 2, Action: nexmosphere.ActionPress},
+			ok:    true,
+		},
+		{
+			name:  "hold",
+			event: nexmosphere.Event{Type: nexmosphere.TypeButton, Action: nexmosphere.ActionHold, Data: "04", Duration: 1500 * time.Millisecond},
+			want:  nexmosphere.ButtonEvent{Button: 4, Action: nexmosphere.ActionHold, Duration: 1500 * time.Millisecond},
+			ok:    true,
+		},
+		{
+			name:  "chord",
+			event: nexmosphere.Event{Type: nexmosphere.TypeButton, Action: nexmosphere.ActionChord, Data: "01+03"},
+			want:  nexmosphere.ButtonEvent{Buttons: []int{1, 3}, Action: nexmosphere.ActionChord},
+			ok:    true,
+		},
+		{
+			name:  "chord of four",
+			event: nexmosphere.Event{Type: nexmosphere.TypeButton, Action: nexmosphere.ActionChord, Data: "01+02+03+04"},
+			want:  nexmosphere.ButtonEvent{Buttons: []int{1, 2, 3, 4}, Action: nexmosphere.ActionChord},
+			ok:    true,
+		},
+		{
+			name:  "not a number",
+			event: nexmosphere.Event{Type: nexmosphere.TypeButton, Action: nexmosphere.ActionPress, Data: "x"},
+		},
+		{
+			name:  "empty data",
+			event: nexmosphere.Event{Type: nexmosphere.TypeButton, Action: nexmosphere.ActionPress},
+		},
+		{
+			name:  "bad chord",
+			event: nexmosphere.Event{Type: nexmosphere.TypeButton, Action: nexmosphere.ActionChord, Data: "01+x"},
+		},
+		{
+			name:  "other type",
+			event: nexmosphere.Event{Type: nexmosphere.TypePresence, Action: nexmosphere.ActionDetectionZone, Data: "2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.event.Button()
+			if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Button() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRFIDPayload(t *testing.T) {
+	tests := []struct {
+		name  string
+		event nexmosphere.Event
+		want  nexmosphere.RFIDEvent
+		ok    bool
+	}{
+		{
+			name:  "tag pickup",
+			event: nexmosphere.Event{Type: nexmosphere.TypeRFIDTag, Action: nexmosphere.ActionPickup, Address: 7},
+			want:  nexmosphere.RFIDEvent{Tag: 7, Action: nexmosphere.ActionPickup},
+			ok:    true,
+		},
+		{
+			name:  "antenna putback",
+			event: nexmosphere.Event{Type: nexmosphere.TypeRFIDAntenna, Action: nexmosphere.ActionPutback, Address: 2, Data: "007"},
+			want:  nexmosphere.RFIDEvent{Tag: 7, Antenna: 2, Action: nexmosphere.ActionPutback},
+			ok:    true,
+		},
+		{
+			name:  "antenna status",
+			event: nexmosphere.Event{Type: nexmosphere.TypeRFIDAntenna, Action: nexmosphere.ActionStatus, Address: 2},
+			want:  nexmosphere.RFIDEvent{Antenna: 2, Action: nexmosphere.ActionStatus},
+			ok:    true,
+		},
+		{
+			name:  "antenna bad tag",
+			event: nexmosphere.Event{Type: nexmosphere.TypeRFIDAntenna, Action: nexmosphere.ActionPickup, Address: 2, Data: "abc"},
+		},
+		{
+			name:  "other type",
+			event: nexmosphere.Event{Type: nexmosphere.TypeButton, Action: nexmosphere.ActionPress, Data: "01"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.event.RFID()
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("RFID() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPresencePayload(t *testing.T) {
+	tests := []struct {
+		name  string
+		event nexmosphere.Event
+		want  nexmosphere.PresenceEvent
+		ok    bool
+	}{
+		{
+			name:  "zone",
+			event: nexmosphere.Event{Type: nexmosphere.TypePresence, Action: nexmosphere.ActionDetectionZone, Data: "2"},
+			want:  nexmosphere.PresenceEvent{Zone: 2, Action: nexmosphere.ActionDetectionZone},
+			ok:    true,
+		},
+		{
+			name:  "zone 0",
+			event: nexmosphere.Event{Type: nexmosphere.TypePresence, Action: nexmosphere.ActionDetectionZone, Data: "0"},
+			want:  nexmosphere.PresenceEvent{Zone: 0, Action: nexmosphere.ActionDetectionZone},
+			ok:    true,
+		},
+		{
+			name:  "not a number",
+			event: nexmosphere.Event{Type: nexmosphere.TypePresence, Action: nexmosphere.ActionDetectionZone, Data: "x"},
+		},
+		{
+			name:  "other type",
+			event: nexmosphere.Event{Type: nexmosphere.TypeDevice, Action: nexmosphere.ActionUpdate, Data: "TYPE=XY240"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.event.Presence()
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("Presence() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDevicePayload(t *testing.T) {
+	tests := []struct {
+		name  string
+		event nexmosphere.Event
+		want  nexmosphere.DeviceEvent
+		ok    bool
+	}{
+		{
+			name:  "type reply",
+			event: nexmosphere.Event{Type: nexmosphere.TypeDevice, Action: nexmosphere.ActionUpdate, Address: 1, Data: "TYPE=XTB4N6"},
+			want:  nexmosphere.DeviceEvent{Address: 1, DeviceType: "XTB4N6", Key: "TYPE", Value: "XTB4N6", Action: nexmosphere.ActionUpdate},
+			ok:    true,
+		},
+		{
+			name:  "serial reply",
+			event: nexmosphere.Event{Type: nexmosphere.TypeDevice, Action: nexmosphere.ActionUpdate, Address: 1, DeviceType: "XTB4N6", Data: "SERIAL=B4-0001"},
+			want:  nexmosphere.DeviceEvent{Address: 1, DeviceType: "XTB4N6", Key: "SERIAL", Value: "B4-0001", Action: nexmosphere.ActionUpdate},
+			ok:    true,
+		},
+		{
+			name:  "detached keeps the event device type",
+			event: nexmosphere.Event{Type: nexmosphere.TypeDevice, Action: nexmosphere.ActionDetached, Address: 3, DeviceType: "XY240", Data: "TYPE=XY240"},
+			want:  nexmosphere.DeviceEvent{Address: 3, DeviceType: "XY240", Key: "TYPE", Value: "XY240", Action: nexmosphere.ActionDetached},
+			ok:    true,
+		},
+		{
+			name:  "value with equals sign",
+			event: nexmosphere.Event{Type: nexmosphere.TypeDevice, Action: nexmosphere.ActionUpdate, Address: 2, Data: "NAME=a=b"},
+			want:  nexmosphere.DeviceEvent{Address: 2, Key: "NAME", Value: "a=b", Action: nexmosphere.ActionUpdate},
+			ok:    true,
+		},
+		{
+			name:  "no key",
+			event: nexmosphere.Event{Type: nexmosphere.TypeDevice, Action: nexmosphere.ActionUpdate, Address: 2, Data: "garbled"},
+			want:  nexmosphere.DeviceEvent{Address: 2, Action: nexmosphere.ActionUpdate},
+			ok:    true,
+		},
+		{
+			name:  "other type",
+			event: nexmosphere.Event{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReady},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.event.Device()
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("Device() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestControllerStatusPayload(t *testing.T) {
+	tests := []struct {
+		name  string
+		event nexmosphere.Event
+		want  nexmosphere.ControllerStatusEvent
+		ok    bool
+	}{
+		{
+			name:  "system update",
+			event: nexmosphere.Event{Type: nexmosphere.TypeController, Action: nexmosphere.ActionSystemUpdate, Data: "controllers=1,handlers=2"},
+			want:  nexmosphere.ControllerStatusEvent{Action: nexmosphere.ActionSystemUpdate, Controllers: 1, Handlers: 2},
+			ok:    true,
+		},
+		{
+			name:  "system update with unknown field",
+			event: nexmosphere.Event{Type: nexmosphere.TypeController, Action: nexmosphere.ActionSystemUpdate, Data: "handlers=3,other=9"},
+			want:  nexmosphere.ControllerStatusEvent{Action: nexmosphere.ActionSystemUpdate, Handlers: 3},
+			ok:    true,
+		},
+		{
+			name:  "reconnected",
+			event: nexmosphere.Event{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReconnected, Data: "attempt 2"},
+			want:  nexmosphere.ControllerStatusEvent{Action: nexmosphere.ActionReconnected, Attempt: 2},
+			ok:    true,
+		},
+		{
+			name:  "rejected",
+			event: nexmosphere.Event{Type: nexmosphere.TypeController, Action: nexmosphere.ActionRejected, Data: "no handshake reply"},
+			want:  nexmosphere.ControllerStatusEvent{Action: nexmosphere.ActionRejected, Message: "no handshake reply"},
+			ok:    true,
+		},
+		{
+			name:  "ready",
+			event: nexmosphere.Event{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReady},
+			want:  nexmosphere.ControllerStatusEvent{Action: nexmosphere.ActionReady},
+			ok:    true,
+		},
+		{
+			name:  "other type",
+			event: nexmosphere.Event{Type: nexmosphere.TypeButton, Action: nexmosphere.ActionPress, Data: "01"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.event.ControllerStatus()
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("ControllerStatus() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+// TestPayloadFromJSON checks payloads are derived from events decoded from their JSON form, as sent over SSE
+func TestPayloadFromJSON(t *testing.T) {
+	raw := `{"type":"device","controller":"sim","address":3,"deviceType":"XY240","action":"detached","data":"TYPE=XY240"}`
+
+	var e nexmosphere.Event
+	if err := json.Unmarshal([]byte(raw), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := e.Device()
+	want := nexmosphere.DeviceEvent{Address: 3, DeviceType: "XY240", Key: "TYPE", Value: "XY240", Action: nexmosphere.ActionDetached}
+	if !ok || got != want {
+		t.Errorf("Device() = %+v, %v, want %+v", got, ok, want)
+	}
+}
@@ -0,0 +1,67 @@
+package nexmosphere
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithReconnectPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ReconnectPolicy
+		want   ReconnectPolicy
+	}{
+		{
+			name:   "zero value",
+			policy: ReconnectPolicy{},
+			want:   ReconnectPolicy{InitialDelay: time.Second, MaxDelay: 30 * time.Second, Multiplier: 1},
+		},
+		{
+			name:   "attempts only",
+			policy: ReconnectPolicy{MaxAttempts: 5},
+			want:   ReconnectPolicy{InitialDelay: time.Second, MaxDelay: 30 * time.Second, Multiplier: 1, MaxAttempts: 5},
+		},
+		{
+			name:   "negative delays",
+			policy: ReconnectPolicy{InitialDelay: -time.Second, MaxDelay: -time.Second, Multiplier: 2},
+			want:   ReconnectPolicy{InitialDelay: time.Second, MaxDelay: 30 * time.Second, Multiplier: 2},
+		},
+		{
+			name:   "max below initial",
+			policy: ReconnectPolicy{InitialDelay: time.Minute, MaxDelay: time.Second, Multiplier: 2},
+			want:   ReconnectPolicy{InitialDelay: time.Minute, MaxDelay: time.Minute, Multiplier: 2},
+		},
+		{
+			name:   "unchanged",
+			policy: ReconnectPolicy{InitialDelay: 50 * time.Millisecond, MaxDelay: time.Second, Multiplier: 3, MaxAttempts: 2},
+			want:   ReconnectPolicy{InitialDelay: 50 * time.Millisecond, MaxDelay: time.Second, Multiplier: 3, MaxAttempts: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{}
+			WithReconnectPolicy(tt.policy)(s)
+			if s.reconnectPolicy != tt.want {
+				t.Errorf("policy = %+v, want %+v", s.reconnectPolicy, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconnectPolicyDelay(t *testing.T) {
+	s := &Service{}
+	WithReconnectPolicy(ReconnectPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 2})(s)
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if d := s.reconnectPolicy.delay(i + 1); d != w {
+			t.Errorf("delay(%d) = %s, want %s", i+1, d, w)
+		}
+	}
+
+	// Delays stay positive however many attempts are made
+	if d := s.reconnectPolicy.delay(10000); d != 5*time.Second {
+		t.Errorf("delay(10000) = %s, want 5s", d)
+	}
+}
@@ -0,0 +1,103 @@
+package nexmosphere
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const defaultQueryTimeout = 5 * time.Second
+
+// queryKey identifies the reply a query is waiting for
+type queryKey struct {
+	address int
+	key     string
+}
+
+// addWaiter registers a channel to receive the reply to a diagnostic query
+func (c *Controller) addWaiter(k queryKey) chan string {
+	reply := make(chan string, 1)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.waiters == nil {
+		c.waiters = make(map[queryKey][]chan string)
+	}
+	c.waiters[k] = append(c.waiters[k], reply)
+	return reply
+}
+
+// removeWaiter unregisters a channel that no longer needs a reply
+func (c *Controller) removeWaiter(k queryKey, reply chan string) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	waiters := c.waiters[k]
+	for i, w := range waiters {
+		if w == reply {
+			c.waiters[k] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(c.waiters[k]) == 0 {
+		delete(c.waiters, k)
+	}
+}
+
+// resolveQuery delivers a diagnostic reply to every query waiting for it
+func (c *Controller) resolveQuery(address int, key string, value string) {
+	k := queryKey{address: address, key: key}
+
+	c.wmu.Lock()
+	waiters := c.waiters[k]
+	delete(c.waiters, k)
+	c.wmu.Unlock()
+
+	for _, w := range waiters {
+		w <- value
+	}
+}
+
+// query sends a diagnostic query and waits for the matching reply
+func (c *Controller) query(ctx context.Context, q queue, address int, key string) (string, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultQueryTimeout)
+		defer cancel()
+	}
+
+	cmd := DiagnosticQuery{Address: address, Key: key}
+	k := queryKey{address: address, key: key}
+
+	// Register before sending so a fast reply can't be missed
+	reply := c.addWaiter(k)
+	if err := c.addToQueue(q, cmd); err != nil {
+		c.removeWaiter(k, reply)
+		return "", err
+	}
+
+	select {
+	case value := <-reply:
+		return value, nil
+	case <-c.done:
+		c.removeWaiter(k, reply)
+		return "", ErrControllerClosed
+	case <-ctx.Done():
+		c.removeWaiter(k, reply)
+		x, _ := cmd.Encode()
+		return "", fmt.Errorf("no reply to %s from %s: %w", x, c.name, ctx.Err())
+	}
+}
+
+// Query sends a diagnostic query to a device and returns the controller's reply
+// For example Query(ctx, controller, 1, "TYPE") returns "XTB4N6". If ctx has no
+// deadline the query times out after 5s
+func (s *Service) Query(ctx context.Context, controllerName string, address int, key string) (string, error) {
+	c, err := s.getController(controllerName)
+	if err != nil {
+		return "", err
+	}
+
+	return c.query(ctx, commandQueue, address, key)
+}
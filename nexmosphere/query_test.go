@@ -0,0 +1,66 @@
+package nexmosphere_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+	"github.org/carrierlabs/go-nexmosphere/simulator"
+)
+
+func TestQuery(t *testing.T) {
+	s := newTestService(t, nexmosphere.WithDeviceAddresses(1))
+	sim := simulator.New("sim", simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+	if err := s.AddController(sim); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if v, err := s.Query(ctx, "sim", 1, "SERIAL"); err != nil || v != "B4-0001" {
+		t.Errorf("Query(1, SERIAL) = %q, %v", v, err)
+	}
+}
+
+// TestQueryController checks the controller's own reply isn't taken for a device at address 0
+func TestQueryController(t *testing.T) {
+	s := newTestService(t, nexmosphere.WithDeviceAddresses(1))
+	events, cancel := s.Subscribe(nexmosphere.Filter{Type: nexmosphere.TypeDevice}, nexmosphere.WithBufferSize(256))
+	defer cancel()
+
+	sim := simulator.New("sim", simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+	if err := s.AddController(sim); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rescan("sim"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancelQuery := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelQuery()
+	if v, err := s.Query(ctx, "sim", 0, "TYPE"); err != nil || v != simulator.DefaultModel {
+		t.Fatalf("Query(0, TYPE) = %q, %v", v, err)
+	}
+
+	// Trigger a system update so every known device is listed again
+	s.AddController(simulator.New("other"))
+
+	time.Sleep(100 * time.Millisecond)
+	for len(events) > 0 {
+		if e := <-events; e.Address == 0 {
+			t.Errorf("device event for the controller: %+v", e)
+		}
+	}
+
+	devices, err := s.GetDevices("sim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range s.GetControllers() {
+		if c.Name == "sim" && c.DeviceCount != len(devices) {
+			t.Errorf("DeviceCount = %d, GetDevices returned %d", c.DeviceCount, len(devices))
+		}
+	}
+}
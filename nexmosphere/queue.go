@@ -0,0 +1,178 @@
+package nexmosphere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrQueueFull is returned when a controller's command queue is at its limit
+var ErrQueueFull = errors.New("command queue full")
+
+// ErrControllerClosed is returned for queued commands that were not sent before the controller closed
+var ErrControllerClosed = errors.New("controller closed")
+
+type queue int
+
+const (
+	systemQueue queue = iota
+	commandQueue
+)
+
+// queuedCommand is an encoded command waiting to be written
+type queuedCommand struct {
+	cmd  string
+	done chan error // Receives the write result, nil if nobody is waiting
+}
+
+// addToQueue encodes a command and adds it to the queue
+func (c *Controller) addToQueue(q queue, cmd Command) error {
+	return c.enqueue(q, cmd, nil)
+}
+
+// enqueue encodes a command and adds it to the queue
+// If done is not nil it receives the result of the write, it must be buffered
+func (c *Controller) enqueue(q queue, cmd Command, done chan error) error {
+	x, err := cmd.Encode()
+	if err != nil {
+		return err
+	}
+
+	c.qmu.Lock()
+	defer c.qmu.Unlock()
+
+	select {
+	case <-c.done:
+		return ErrControllerClosed
+	default:
+	}
+
+	// User commands are bounded, system commands are always accepted
+	if q == commandQueue && c.service.queueLength > 0 && len(c.queue[q]) >= c.service.queueLength {
+		return fmt.Errorf("%w: %s has %d pending commands", ErrQueueFull, c.name, len(c.queue[q]))
+	}
+
+	c.queue[q] = append(c.queue[q], queuedCommand{cmd: x, done: done})
+	c.signalQueue()
+	return nil
+}
+
+// signalQueue wakes processQueue if it is waiting for commands
+func (c *Controller) signalQueue() {
+	select {
+	case c.queued <- struct{}{}:
+	default:
+	}
+}
+
+// getFromQueue returns the next command from the queue
+func (c *Controller) getFromQueue() (queuedCommand, bool) {
+	c.qmu.Lock()
+	defer c.qmu.Unlock()
+
+	var q queue
+	var x queuedCommand
+	switch {
+	case len(c.queue[systemQueue]) > 0:
+		q = systemQueue
+	case len(c.queue[commandQueue]) > 0:
+		q = commandQueue
+	default:
+		return x, false
+	}
+	x, c.queue[q] = c.queue[q][0], c.queue[q][1:]
+	return x, true
+}
+
+// processQueue writes one queued command per interval until the controller closes
+// If interval is 0 or less commands are written as soon as they are queued
+func (c *Controller) processQueue(interval time.Duration) {
+	if interval <= 0 {
+		for {
+			select {
+			case <-c.queued:
+				for c.writeNext() {
+				}
+			case <-c.done:
+				return
+			}
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.writeNext()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// writeNext writes the next queued command, returns false if there was nothing to write
+func (c *Controller) writeNext() bool {
+	// Hold commands while reconnecting
+	if !c.isConnected() {
+		return false
+	}
+
+	x, ok := c.getFromQueue()
+	if !ok {
+		return false
+	}
+
+	err := c.write(x.cmd)
+	if x.done != nil {
+		x.done <- err
+	}
+	return true
+}
+
+// drainQueue fails every command still waiting in the queue
+func (c *Controller) drainQueue() {
+	c.qmu.Lock()
+	defer c.qmu.Unlock()
+
+	for q := range c.queue {
+		for _, x := range c.queue[q] {
+			if x.done != nil {
+				x.done <- ErrControllerClosed
+			}
+		}
+		c.queue[q] = nil
+	}
+}
+
+// waitForWrite blocks until a queued command is written or the context ends
+// If the context ends first the command is withdrawn from the queue and ctx.Err() is returned,
+// unless it is already being written, then the result of the write is returned
+func (c *Controller) waitForWrite(ctx context.Context, q queue, done chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	if c.withdraw(q, done) {
+		return ctx.Err()
+	}
+	return <-done
+}
+
+// withdraw removes a command that is still waiting from the queue, returns false if it isn't there
+func (c *Controller) withdraw(q queue, done chan error) bool {
+	c.qmu.Lock()
+	defer c.qmu.Unlock()
+
+	for i, x := range c.queue[q] {
+		if x.done == done {
+			c.queue[q] = append(c.queue[q][:i:i], c.queue[q][i+1:]...)
+			return true
+		}
+	}
+	return false
+}
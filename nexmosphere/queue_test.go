@@ -0,0 +1,70 @@
+package nexmosphere_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+	"github.org/carrierlabs/go-nexmosphere/simulator"
+)
+
+// TestZeroIntervals checks a zero command and scan interval disable pacing and periodic scans
+func TestZeroIntervals(t *testing.T) {
+	s := newTestService(t,
+		nexmosphere.WithCommandInterval(0),
+		nexmosphere.WithScanInterval(0),
+		nexmosphere.WithAutoScan(true),
+		nexmosphere.WithDeviceAddresses(1),
+	)
+
+	sim := simulator.New("sim", simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+	if err := s.AddController(sim); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := s.Send("sim", nexmosphere.XTalkCommand{Address: 1, Format: "A", Data: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !sim.WaitForCommand("X001A[9]", 5*time.Second) {
+		t.Fatalf("commands not written, got %v", sim.Commands())
+	}
+}
+
+// TestSendWaitCancelWithdraws checks a command whose context ends before it is written is never sent
+func TestSendWaitCancelWithdraws(t *testing.T) {
+	s := newTestService(t,
+		nexmosphere.WithCommandInterval(200*time.Millisecond),
+		nexmosphere.WithDeviceAddresses(1),
+	)
+
+	sim := simulator.New("sim", simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+	if err := s.AddController(sim); err != nil {
+		t.Fatal(err)
+	}
+
+	// Queue behind a command so the second one is still waiting when ctx ends
+	if err := s.Send("sim", nexmosphere.XTalkCommand{Address: 1, Format: "A", Data: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := s.SendWait(ctx, "sim", nexmosphere.XTalkCommand{Address: 1, Format: "A", Data: "2"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("SendWait() = %v, want context.DeadlineExceeded", err)
+	}
+
+	// A command queued afterwards is written, the withdrawn one never is
+	if err := s.SendWait(context.Background(), "sim", nexmosphere.XTalkCommand{Address: 1, Format: "A", Data: "3"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range sim.Commands() {
+		if cmd == "X001A[2]" {
+			t.Fatalf("withdrawn command was written: %v", sim.Commands())
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package nexmosphere_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+	"github.org/carrierlabs/go-nexmosphere/simulator"
+	"go.uber.org/zap"
+)
+
+// newTestService returns a started service without port scanning or settle delay
+func newTestService(t *testing.T, opts ...nexmosphere.Option) *nexmosphere.Service {
+	t.Helper()
+
+	opts = append([]nexmosphere.Option{
+		nexmosphere.WithLogger(zap.NewNop().Sugar()),
+		nexmosphere.WithAutoScan(false),
+		nexmosphere.WithSettleDelay(0),
+		nexmosphere.WithCommandInterval(time.Millisecond),
+	}, opts...)
+
+	s := nexmosphere.NewService(opts...)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop() })
+	return s
+}
+
+// waitForEvent returns the first event from events matching filter
+func waitForEvent(t *testing.T, events <-chan nexmosphere.Event, filter nexmosphere.Filter, timeout time.Duration) nexmosphere.Event {
+	t.Helper()
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("events closed waiting for %+v", filter)
+			}
+			if filter.Match(e) {
+				return e
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for %+v", filter)
+		}
+	}
+}
+
+// TestConcurrentAccess drives feedback, commands and queries from many goroutines at once
+// Run with -race to check the controller and device state is guarded
+func TestConcurrentAccess(t *testing.T) {
+	s := newTestService(t,
+		nexmosphere.WithDeviceAddresses(1, 2, 3),
+		nexmosphere.WithRescanInterval(20*time.Millisecond),
+	)
+	s.AddHandler(nexmosphere.EventHandlerFunc(func(e nexmosphere.Event) {}))
+	events, cancel := s.Subscribe(nexmosphere.Filter{}, nexmosphere.WithBufferSize(1024))
+	defer cancel()
+
+	sim := simulator.New("sim",
+		simulator.WithDevice(1, "XTB4N6", "B4-0001"),
+		simulator.WithDevice(2, "XRDR1", "RD-0001"),
+		simulator.WithDevice(3, "XY240", "XY-0001"),
+	)
+	if err := s.AddController(sim); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReady}, 5*time.Second)
+
+	workers := []func(){
+		func() { sim.PressButton(1, 1); sim.ReleaseButton(1, 1) },
+		func() { sim.SetButtons(1, 0x6); sim.SetButtons(1, 0) },
+		func() { sim.Putback(2, 4); sim.Pickup(2, 4) },
+		func() { sim.SetZone(3, 1); sim.SetZone(3, 2) },
+		func() { sim.Emit("X009A[1]"); sim.Emit("garbage") },
+		func() { s.GetControllers() },
+		func() { s.GetDevices("sim") },
+		func() { s.GetDevice("sim", 1) },
+		func() { s.SetDeviceHoldInterval("sim", 1, time.Millisecond) },
+		func() { s.SetButtonLED("sim", 1, 2, nexmosphere.LEDBlink) },
+		func() { s.Send("sim", nexmosphere.XTalkCommand{Address: 1, Format: "A", Data: "1"}) },
+		func() { s.Rescan("sim") },
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for _, f := range workers {
+		f := f
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					f()
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}()
+	}
+
+	// Drain events so the subscription keeps up
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for {
+			select {
+			case <-events:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	time.Sleep(time.Second)
+	close(stop)
+	wg.Wait()
+	<-drained
+
+	devices, err := s.GetDevices("sim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 3 {
+		t.Errorf("GetDevices returned %d devices, want 3: %+v", len(devices), devices)
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
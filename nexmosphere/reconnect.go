@@ -0,0 +1,229 @@
+package nexmosphere
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ReconnectPolicy controls how a lost controller is reopened
+// Delays grow from InitialDelay by Multiplier after each failed attempt, up to MaxDelay
+type ReconnectPolicy struct {
+	InitialDelay time.Duration // Delay before the first attempt, DefaultReconnectPolicy's if not positive
+	MaxDelay     time.Duration // Upper bound for the delay between attempts, DefaultReconnectPolicy's if not positive
+	Multiplier   float64       // Growth factor applied to the delay after each failed attempt
+	MaxAttempts  int           // Attempts before the controller is removed, 0 for no limit
+}
+
+// DefaultReconnectPolicy retries for roughly three minutes before giving up
+var DefaultReconnectPolicy = ReconnectPolicy{
+	InitialDelay: time.Second,
+	MaxDelay:     30 * time.Second,
+	Multiplier:   2,
+	MaxAttempts:  10,
+}
+
+// delay returns the delay before a reconnect attempt, attempts are numbered from 1
+func (p ReconnectPolicy) delay(attempt int) time.Duration {
+	d := p.InitialDelay
+	for i := 1; i < attempt; i++ {
+		d = time.Duration(float64(d) * p.Multiplier)
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return d
+}
+
+// WithReconnectPolicy sets how lost controllers are reopened (default: DefaultReconnectPolicy)
+func WithReconnectPolicy(policy ReconnectPolicy) Option {
+	return func(s *Service) {
+		if policy.Multiplier < 1 {
+			policy.Multiplier = 1
+		}
+		// Without a delay a missing port would be reopened in a tight loop
+		if policy.InitialDelay <= 0 {
+			policy.InitialDelay = DefaultReconnectPolicy.InitialDelay
+		}
+		if policy.MaxDelay <= 0 {
+			policy.MaxDelay = DefaultReconnectPolicy.MaxDelay
+		}
+		if policy.MaxDelay < policy.InitialDelay {
+			policy.MaxDelay = policy.InitialDelay
+		}
+		s.reconnectPolicy = policy
+	}
+}
+
+// runController listens to a controller, reconnecting whenever the connection is lost,
+// and removes it once it can't be recovered or the service stops
+func (s *Service) runController(ctx context.Context, c *Controller) {
+	for {
+		err := c.listen()
+
+		// Close the controller
+		if closeErr := c.close(); closeErr != nil {
+			s.logger.Debugf("Error closing controller %s: %s", c.name, closeErr)
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		s.logger.Errorf("Connection lost: %s: %v", c.name, err)
+		if c.reopen == nil || !s.reconnect(ctx, c, err) {
+			break
+		}
+	}
+
+	s.logger.Infof("Closing: %s", c.name)
+
+	// Remove from map
+	s.mu.Lock()
+	delete(s.controllers, c.id)
+	s.mu.Unlock()
+
+	close(c.done)
+	c.drainQueue()
+	s.sendSystemUpdate()
+}
+
+// reconnect reopens a lost controller following the reconnect policy
+// Returns true once the controller is connected again
+func (s *Service) reconnect(ctx context.Context, c *Controller, cause error) bool {
+	data := "connection lost"
+	if cause != nil {
+		data = cause.Error()
+	}
+	s.dispatch(Event{
+		Type:       TypeController,
+		Controller: c.name,
+		Action:     ActionDisconnected,
+		Data:       data,
+	})
+
+	policy := s.reconnectPolicy
+	for attempt := 1; policy.MaxAttempts == 0 || attempt <= policy.MaxAttempts; attempt++ {
+		delay := policy.delay(attempt)
+		s.logger.Infof("Reconnecting to %s in %s (attempt %d)", c.name, delay, attempt)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false
+		}
+
+		if err := s.reopenController(ctx, c); err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			s.logger.Debugf("Reconnect to %s failed: %s", c.name, err)
+			continue
+		}
+
+		s.logger.Infof("Reconnected to %s", c.name)
+		s.dispatch(Event{
+			Type:       TypeController,
+			Controller: c.name,
+			Action:     ActionReconnected,
+			Data:       fmt.Sprintf("attempt %d", attempt),
+		})
+
+		s.restoreController(c)
+		return true
+	}
+
+	s.logger.Errorf("Giving up on %s after %d attempts", c.name, policy.MaxAttempts)
+	return false
+}
+
+// reopenController reopens the port of a lost controller and repeats the handshake
+func (s *Service) reopenController(ctx context.Context, c *Controller) error {
+	t, err := c.reopen()
+	if err != nil {
+		return err
+	}
+	c.setTransport(t)
+
+	if s.probeTimeout > 0 {
+		if _, err := c.probe(ctx, s.probeTimeout); err != nil {
+			c.close()
+			return err
+		}
+	}
+
+	// Stop may have closed the old port while this one was opening
+	if ctx.Err() != nil {
+		c.close()
+		return ctx.Err()
+	}
+
+	c.setConnected(true)
+	return nil
+}
+
+// restoreController re-enumerates devices and reapplies settings after a reconnect
+// Device state held by the library, such as hold intervals, is kept across reconnects
+func (s *Service) restoreController(c *Controller) {
+	for _, address := range c.rescanAddresses() {
+		c.addToQueue(systemQueue, DiagnosticQuery{Address: address, Key: "TYPE"})
+	}
+
+	// Let drivers refresh the state of known devices, such as the tags on RFID antennas,
+	// and relight button LEDs
+	c.mu.Lock()
+	for i, d := range c.devices {
+		if i > 0 && d != nil && d.Type != "" {
+			c.initDevice(i, d.Type)
+			if driver := driverFor(d.Type); driver != nil {
+				for _, cmd := range d.ledCommands(driver, i) {
+					c.addToQueue(systemQueue, cmd)
+				}
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	for _, cmd := range c.savedSettings() {
+		c.addToQueue(systemQueue, cmd)
+	}
+}
+
+// saveSetting records a setting written to a device so it can be restored after a reconnect
+func (c *Controller) saveSetting(cmd SettingCommand) {
+	c.qmu.Lock()
+	defer c.qmu.Unlock()
+
+	if c.settings == nil {
+		c.settings = make(map[int]map[string]string)
+	}
+	if c.settings[cmd.Address] == nil {
+		c.settings[cmd.Address] = make(map[string]string)
+	}
+	c.settings[cmd.Address][cmd.Setting] = cmd.Value
+}
+
+// savedSettings returns every recorded setting, ordered by address and setting
+func (c *Controller) savedSettings() []SettingCommand {
+	c.qmu.Lock()
+	defer c.qmu.Unlock()
+
+	cmds := make([]SettingCommand, 0)
+	for address, settings := range c.settings {
+		for setting, value := range settings {
+			cmds = append(cmds, SettingCommand{Address: address, Setting: setting, Value: value})
+		}
+	}
+
+	sort.Slice(cmds, func(i, j int) bool {
+		if cmds[i].Address != cmds[j].Address {
+			return cmds[i].Address < cmds[j].Address
+		}
+		return cmds[i].Setting < cmds[j].Setting
+	})
+	return cmds
+}
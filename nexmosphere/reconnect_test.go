@@ -0,0 +1,73 @@
+package nexmosphere_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+	"github.org/carrierlabs/go-nexmosphere/simulator"
+)
+
+// TestReconnectKeepsDevices checks periodic rescans don't detach devices while a controller
+// is reconnecting, so their settings and LEDs are restored
+func TestReconnectKeepsDevices(t *testing.T) {
+	addr, sims := newTCPBridge(t, simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+	name := "tcp://" + addr
+
+	s := newTestService(t,
+		nexmosphere.WithDeviceAddresses(1),
+		nexmosphere.WithRescanInterval(20*time.Millisecond),
+		nexmosphere.WithReconnectPolicy(nexmosphere.ReconnectPolicy{InitialDelay: 6 * time.Second, MaxDelay: 10 * time.Second, Multiplier: 2}),
+	)
+	events, cancel := s.Subscribe(nexmosphere.Filter{Controller: name}, nexmosphere.WithBufferSize(256))
+	defer cancel()
+
+	if err := s.AddTCPController(addr); err != nil {
+		t.Fatal(err)
+	}
+	sim := receiveSim(t, sims)
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReady}, 5*time.Second)
+
+	if err := s.SetButtonLED(name, 1, 2, nexmosphere.LEDBlink); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendWait(context.Background(), name, nexmosphere.SettingCommand{Address: 1, Setting: "3", Value: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sim.Close()
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionDisconnected}, 5*time.Second)
+
+	// Rescans while reconnecting are refused, the outage outlasts the rescan query timeout
+	if err := s.Rescan(name); err == nil {
+		t.Error("Rescan succeeded while reconnecting")
+	}
+
+	sim = receiveSim(t, sims)
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReconnected}, 10*time.Second)
+
+	for _, cmd := range []string{"X001B[LED2=BLINK]", "X001S[3:1]"} {
+		if !sim.WaitForCommand(cmd, 5*time.Second) {
+			t.Errorf("%s not restored, commands %v", cmd, sim.Commands())
+		}
+	}
+
+	// Give the rescan loop time to run against the restored connection
+	time.Sleep(200 * time.Millisecond)
+	for {
+		select {
+		case e := <-events:
+			if e.Type == nexmosphere.TypeDevice && (e.Action == nexmosphere.ActionDetached || e.Action == nexmosphere.ActionAttached) {
+				t.Errorf("unexpected %s event for address %d", e.Action, e.Address)
+			}
+			continue
+		default:
+		}
+		break
+	}
+
+	if d, err := s.GetDevice(name, 1); err != nil || d.Buttons[1].LED != nexmosphere.LEDBlink {
+		t.Errorf("GetDevice() = %+v, %v", d, err)
+	}
+}
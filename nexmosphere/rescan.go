@@ -0,0 +1,158 @@
+package nexmosphere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotConnected is returned when a controller can't be rescanned because it is reconnecting
+var ErrNotConnected = errors.New("controller not connected")
+
+// identifyRetryInterval is how long to wait before re-identifying a device that didn't answer
+const identifyRetryInterval = readyTimeout
+
+// Rescan re-enumerates the devices on a controller, blocking until every address has answered or timed out
+// Newly connected devices are reported with a "device"/"attached" event, devices
+// that no longer answer with a "device"/"detached" event. While the controller is
+// reconnecting it returns an error wrapping ErrNotConnected
+func (s *Service) Rescan(controllerName string) error {
+	ctx, err := s.runningContext()
+	if err != nil {
+		return err
+	}
+
+	c, err := s.getController(controllerName)
+	if err != nil {
+		return err
+	}
+
+	return c.rescan(ctx)
+}
+
+// rescan queries the type of every probed or known device and detaches those that don't answer
+// Devices are kept if the connection is lost during the rescan, their queries time out because
+// the queue is held, not because they were unplugged
+func (c *Controller) rescan(ctx context.Context) error {
+	connected, lost := c.connection()
+	if !connected {
+		return fmt.Errorf("%w: %s is reconnecting", ErrNotConnected, c.name)
+	}
+
+	addresses := c.rescanAddresses()
+	timeout := readyTimeout + time.Duration(len(addresses))*c.service.commandInterval
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	c.service.logger.Debugf("Rescanning %d address(es) on %s", len(addresses), c.name)
+
+	// Query every address concurrently, replies are handled by listen
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	missing := make([]int, 0)
+	for _, address := range addresses {
+		address := address
+		wg.Add(1)
+		c.service.spawn(func() {
+			defer wg.Done()
+			if _, err := c.query(ctx, systemQueue, address, "TYPE"); err != nil {
+				mu.Lock()
+				missing = append(missing, address)
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+
+	select {
+	case <-c.done:
+		return ErrControllerClosed
+	default:
+	}
+
+	if connected, l := c.connection(); !connected || l != lost {
+		c.service.logger.Debugf("Connection to %s lost during rescan, keeping devices", c.name)
+		return fmt.Errorf("%w: %s disconnected during rescan", ErrNotConnected, c.name)
+	}
+
+	// Anything that had a type and didn't answer has been unplugged
+	sort.Ints(missing)
+
+	c.mu.Lock()
+	defer c.unlock()
+
+	for _, address := range missing {
+		d := c.getDevice(address)
+		if d.Type == "" {
+			continue
+		}
+
+		previous := d.Type
+		d.Type = ""
+		d.resetState()
+		c.service.logger.Infof("Device %s detached from %s address %d", previous, c.name, address)
+		c.emit(Event{
+			Type:       TypeDevice,
+			Controller: c.name,
+			Address:    address,
+			DeviceType: previous,
+			Action:     ActionDetached,
+			Data:       fmt.Sprintf("TYPE=%s", previous),
+		})
+	}
+
+	return nil
+}
+
+// rescanAddresses returns the probed addresses plus any other address with a known device
+func (c *Controller) rescanAddresses() []int {
+	addresses := append([]int{}, c.service.deviceAddresses(c)...)
+
+	c.mu.Lock()
+	for i, d := range c.devices {
+		if i > 0 && d != nil && d.Type != "" {
+			addresses = append(addresses, i)
+		}
+	}
+	c.mu.Unlock()
+	return normalizeAddresses(addresses)
+}
+
+// identify queries the type of a device that sent feedback before it was known
+// Repeated feedback doesn't flood the controller, the query is retried at most once per identifyRetryInterval
+// Must be called with c.mu held
+func (c *Controller) identify(address int) {
+	if c.pendingDevices[address] {
+		return
+	}
+
+	if last, ok := c.identifying[address]; ok && time.Since(last) < identifyRetryInterval {
+		return
+	}
+	c.identifying[address] = time.Now()
+
+	c.service.logger.Debugf("Identifying unknown device on %s address %d", c.name, address)
+	c.addToQueue(systemQueue, DiagnosticQuery{Address: address, Key: "TYPE"})
+}
+
+// rescanLoop periodically rescans a ready controller until it closes
+func (c *Controller) rescanLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Skip rescans while reconnecting, restoreController re-enumerates devices
+			if c.isReady() && c.isConnected() {
+				c.rescan(ctx)
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
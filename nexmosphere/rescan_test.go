@@ -0,0 +1,99 @@
+package nexmosphere_test
+
+import (
+	"testing"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+	"github.org/carrierlabs/go-nexmosphere/simulator"
+)
+
+func TestRescanAttachDetach(t *testing.T) {
+	s := newTestService(t, nexmosphere.WithDeviceAddresses(1, 2))
+	events, cancel := s.Subscribe(nexmosphere.Filter{Type: nexmosphere.TypeDevice}, nexmosphere.WithBufferSize(256))
+	defer cancel()
+
+	sim := simulator.New("sim", simulator.WithDevice(1, "XTB4N6", "B4-0001"), simulator.WithDevice(2, "XY240", "XY-0001"))
+	if err := s.AddController(sim); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rescan("sim"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Swap the presence sensor for a button interface
+	sim.AddDevice(2, "XTB4N6", "B4-0002")
+	if err := s.Rescan("sim"); err != nil {
+		t.Fatal(err)
+	}
+	e := waitForEvent(t, events, nexmosphere.Filter{Action: nexmosphere.ActionDetached}, 5*time.Second)
+	if e.Address != 2 || e.DeviceType != "XY240" {
+		t.Errorf("detached event = %+v", e)
+	}
+	e = waitForEvent(t, events, nexmosphere.Filter{Action: nexmosphere.ActionAttached}, 5*time.Second)
+	if e.Address != 2 || e.DeviceType != "XTB4N6" {
+		t.Errorf("attached event = %+v", e)
+	}
+
+	if d, err := s.GetDevice("sim", 2); err != nil || d.Type != "XTB4N6" {
+		t.Errorf("GetDevice() = %+v, %v", d, err)
+	}
+}
+
+// TestSwapStopsHold checks a held button stops raising hold events once its device is swapped
+func TestSwapStopsHold(t *testing.T) {
+	s := newTestService(t, nexmosphere.WithDeviceAddresses(1))
+	events, cancel := s.Subscribe(nexmosphere.Filter{Address: 1}, nexmosphere.WithBufferSize(256))
+	defer cancel()
+
+	sim := simulator.New("sim", simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+	if err := s.AddController(sim); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rescan("sim"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDeviceHoldInterval("sim", 1, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	sim.PressButton(1, 1)
+	waitForEvent(t, events, nexmosphere.Filter{Action: nexmosphere.ActionHold}, 5*time.Second)
+
+	sim.AddDevice(1, "XY240", "XY-0001")
+	if err := s.Rescan("sim"); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, nexmosphere.Filter{Action: nexmosphere.ActionAttached}, 5*time.Second)
+
+	// Drain what was raised before the swap, then no more holds may arrive
+	time.Sleep(50 * time.Millisecond)
+	for len(events) > 0 {
+		<-events
+	}
+	time.Sleep(100 * time.Millisecond)
+	for len(events) > 0 {
+		if e := <-events; e.Action == nexmosphere.ActionHold {
+			t.Fatalf("hold event after the device was swapped: %+v", e)
+		}
+	}
+
+	// Swap back, the new interface starts with every button open
+	sim.AddDevice(1, "XTB4N6", "B4-0002")
+	if err := s.Rescan("sim"); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := s.GetDevice("sim", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Type != "XTB4N6" || len(d.Buttons) != 4 {
+		t.Fatalf("GetDevice() = %+v", d)
+	}
+	for _, b := range d.Buttons {
+		if b.Closed || b.Pressed || !b.PressedAt.IsZero() {
+			t.Errorf("button state kept after swap: %+v", b)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package nexmosphere_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+	"github.org/carrierlabs/go-nexmosphere/simulator"
+)
+
+// TestRFIDFrameInBetween checks an antenna frame refers to the last tag frame,
+// even when feedback from another device or a diagnostic reply arrives in between
+func TestRFIDFrameInBetween(t *testing.T) {
+	for name, between := range map[string]string{
+		"button":     "X001A[3]",
+		"diagnostic": "D002B[TYPE=XRDR1]",
+	} {
+		between := between
+		t.Run(name, func(t *testing.T) {
+			s := newTestService(t, nexmosphere.WithDeviceAddresses(1, 2))
+			events, cancel := s.Subscribe(nexmosphere.Filter{}, nexmosphere.WithBufferSize(256))
+			defer cancel()
+
+			sim := simulator.New("sim",
+				simulator.WithDevice(1, "XTB4N6", "B4-0001"),
+				simulator.WithDevice(2, "XRDR1", "RD-0001"),
+			)
+			if err := s.AddController(sim); err != nil {
+				t.Fatal(err)
+			}
+			waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReady}, 5*time.Second)
+
+			sim.Emit("XR[PB007]")
+			sim.Emit(between)
+			sim.Emit("X002A[0]")
+
+			e := waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeRFIDAntenna, Action: nexmosphere.ActionPutback}, 5*time.Second)
+			if e.Address != 2 || e.Data != "007" {
+				t.Errorf("putback event = %+v, want tag 007 on address 2", e)
+			}
+
+			d, err := s.GetDevice("sim", 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(d.Tags, []int{7}) {
+				t.Errorf("Tags = %v, want [7]", d.Tags)
+			}
+		})
+	}
+}
@@ -1,16 +1,17 @@
 package nexmosphere
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
 	"strings"
-	"time"
 
-	"go.bug.st/serial"
 	"go.bug.st/serial/enumerator"
 )
 
 // scanForControllers enumerates over all connected USB devices identifying Nexmosphere controllers
-func (s *Service) scanForControllers() {
+func (s *Service) scanForControllers(ctx context.Context) {
 	// Get all possible Serial Ports
 	ports, err := enumerator.GetDetailedPortsList()
 	if err != nil {
@@ -18,6 +19,22 @@ func (s *Service) scanForControllers() {
 		return
 	}
 
+	// Forget rejected ports once they are unplugged
+	s.mu.Lock()
+	for name := range s.rejected {
+		found := false
+		for _, port := range ports {
+			if port.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			delete(s.rejected, name)
+		}
+	}
+	s.mu.Unlock()
+
 	if len(ports) == 0 {
 		s.logger.Debugf("No serial ports found")
 		return
@@ -39,86 +56,86 @@ func (s *Service) scanForControllers() {
 			continue
 		}
 
-		// If port already added, bail
-		s.mu.RLock()
-		_, exists := s.controllers[port.Name]
-		s.mu.RUnlock()
-
-		if exists {
+		// If port is manually configured, leave it to its own supervisor
+		if s.isStaticPort(port.Name) {
 			continue
 		}
 
-		// Create controller and open port
-		c, err := s.openController(port)
-		if err != nil {
-			s.logger.Debugf("Failed to open controller %s: %s", port.Name, err)
+		// If port already added, being initialised or failed the handshake, bail
+		s.mu.Lock()
+		exists := s.portInUse(port.Name, controllerID(TransportInfo{
+			Name:         port.Name,
+			IsUSB:        port.IsUSB,
+			SerialNumber: port.SerialNumber,
+		}))
+		pending := s.pending[port.Name]
+		rejected := s.rejected[port.Name]
+		if !exists && !pending && !rejected {
+			s.pending[port.Name] = true
+		}
+		s.mu.Unlock()
+
+		if exists || pending || rejected {
 			continue
 		}
 
+		// Initialise each new port concurrently so slow ports don't hold up the scan
+		port := port
+		s.spawn(func() { s.initPort(ctx, port) })
+	}
+}
+
+// initPort opens a newly discovered port and runs its initialisation pipeline
+func (s *Service) initPort(ctx context.Context, port *enumerator.PortDetails) {
+	defer func() {
 		s.mu.Lock()
-		s.controllers[port.Name] = c
+		delete(s.pending, port.Name)
 		s.mu.Unlock()
+	}()
 
-		s.sendSystemUpdate()
+	// Open the port
+	t, err := openSerialTransport(port, nil)
+	if err != nil {
+		s.logger.Debugf("Failed to open controller %s: %s", port.Name, err)
+		return
+	}
 
-		// Listen to port, cleanup on close
-		s.logger.Infof("Listening: %v", port.Name)
-		go func(c *Controller) {
-			err := c.listen()
-			s.logger.Errorf("Closing: %s: %s", c.name, err)
+	// The adapter may come back on a different port name, so reopen it by serial number
+	reopen := func() (Transport, error) {
+		return openSerialTransport(findUSBPort(port), nil)
+	}
 
-			// Close the controller
-			if closeErr := c.close(); closeErr != nil {
-				s.logger.Errorf("Error closing controller %s: %s", c.name, closeErr)
-			}
+	if _, err := s.setupController(ctx, t, reopen); err != nil {
+		s.logger.Debugf("Failed to attach controller %s: %s", port.Name, err)
+		t.Close()
 
-			// Remove from map
+		if errors.Is(err, ErrNotNexmosphere) {
 			s.mu.Lock()
-			delete(s.controllers, c.name)
+			s.rejected[port.Name] = true
 			s.mu.Unlock()
-
-			s.sendSystemUpdate()
-		}(c)
-
-		// Pause before starting comms ticker
-		time.Sleep(10 * time.Second)
-
-		// Send commands to get device information
-		c.pendingDeviceQueries = 8
-		for i := 1; i <= 8; i++ {
-			s.logger.Debugf("Sending info request to address %d", i)
-			c.addToQueue(systemQueue, fmt.Sprintf("D%03dB[TYPE]", i))
 		}
+	}
+}
 
-		// Timeout for ready state if devices don't respond
-		go func(ctrl *Controller) {
-			time.Sleep(5 * time.Second)
-			if !ctrl.ready {
-				ctrl.ready = true
-				devicesFound := 8 - ctrl.pendingDeviceQueries
-				s.logger.Infof("Controller %s ready - %d device(s) found", ctrl.name, devicesFound)
-				s.dispatch(Event{
-					Type:       "controller",
-					Controller: ctrl.name,
-					Action:     "ready",
-					Data:       fmt.Sprintf("%d device(s) found", devicesFound),
-				})
-			}
-		}(c)
+// findUSBPort returns the current details of a USB adapter, matched by serial number
+// If the adapter reports no serial number, or isn't found, the original details are returned
+func findUSBPort(port *enumerator.PortDetails) *enumerator.PortDetails {
+	if !port.IsUSB || port.SerialNumber == "" {
+		return port
+	}
 
-		// Start command queue processor
-		go func(c *Controller) {
-			c.qTimer = time.NewTicker(250 * time.Millisecond)
-			defer c.qTimer.Stop()
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return port
+	}
 
-			for range c.qTimer.C {
-				cmd := c.getFromQueue()
-				if cmd != "" {
-					c.write(cmd)
-				}
-			}
-		}(c)
+	for _, p := range ports {
+		if p.IsUSB && p.SerialNumber == port.SerialNumber &&
+			strings.EqualFold(p.VID, port.VID) && strings.EqualFold(p.PID, port.PID) {
+			return p
+		}
 	}
+	return port
 }
 
 // checkForUSB returns true if a port matches Nexmosphere USB profile
@@ -144,36 +161,25 @@ func checkForRS232(_ *enumerator.PortDetails) bool {
 	return false
 }
 
-// openController opens and configures a controller
-func (s *Service) openController(port *enumerator.PortDetails) (*Controller, error) {
-	c := &Controller{
-		md: controllerMD{
-			serialNo:    "",
-			productCode: "",
-			vid:         port.VID,
-			pid:         port.PID,
-		},
-		name:    port.Name,
-		isUSB:   port.IsUSB,
-		service: s,
-	}
-
-	// Configure Serial (RS232) Mode
-	mode := &serial.Mode{
-		BaudRate: 115200,
-		DataBits: 8,
-		Parity:   serial.NoParity,
-		StopBits: serial.OneStopBit,
+// isStaticPort returns true if a port is configured with WithStaticController
+// Symlinks such as /dev/serial/by-id/... are resolved before comparing
+func (s *Service) isStaticPort(name string) bool {
+	resolved := resolvePortPath(name)
+	for _, sc := range s.staticControllers {
+		if resolvePortPath(sc.path) == resolved {
+			return true
+		}
 	}
+	return false
+}
 
-	// Open the port
-	var err error
-	c.port, err = serial.Open(port.Name, mode)
+// resolvePortPath returns the device a port path points to, or the path itself if it can't be resolved
+func resolvePortPath(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
 	if err != nil {
-		return nil, err
+		return path
 	}
-
-	return c, nil
+	return resolved
 }
 
 // sendSystemUpdate dispatches a system update event
@@ -184,8 +190,8 @@ func (s *Service) sendSystemUpdate() {
 	s.mu.RUnlock()
 
 	event := Event{
-		Type:   "controller",
-		Action: "system-update",
+		Type:   TypeController,
+		Action: ActionSystemUpdate,
 		Data:   fmt.Sprintf("controllers=%d,handlers=%d", controllerCount, handlerCount),
 	}
 
@@ -200,17 +206,20 @@ func (s *Service) sendSystemUpdate() {
 	s.mu.RUnlock()
 
 	for _, c := range controllers {
+		c.mu.Lock()
 		for i, d := range c.devices {
-			if d != nil && d.Type != "" {
+			if i > 0 && d != nil && d.Type != "" {
 				deviceEvent := Event{
-					Type:       "device",
+					Type:       TypeDevice,
 					Controller: c.name,
 					Address:    i,
-					Action:     "update",
+					DeviceType: d.Type,
+					Action:     ActionUpdate,
 					Data:       fmt.Sprintf("TYPE=%s", d.Type),
 				}
-				s.dispatch(deviceEvent)
+				c.emit(deviceEvent)
 			}
 		}
+		c.unlock()
 	}
 }
@@ -1,23 +1,48 @@
 package nexmosphere
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"go.bug.st/serial"
 	"go.uber.org/zap"
 )
 
 // Service manages Nexmosphere controllers and dispatches events to handlers
 type Service struct {
-	controllers  map[string]*Controller
-	handlers     []EventHandler
-	logger       *zap.SugaredLogger
-	scanTicker   *time.Ticker
-	scanInterval time.Duration
-	stopChan     chan struct{}
-	mu           sync.RWMutex
-	running      bool
+	controllers         map[string]*Controller
+	staticControllers   []staticController
+	handlers            []*registeredHandler
+	nextHandlerID       HandlerID
+	deliveryMode        DeliveryMode
+	subscriptions       []*subscription
+	logger              *zap.SugaredLogger
+	tcpControllers      []string
+	autoScan            bool
+	scanInterval        time.Duration
+	settleDelay         time.Duration
+	probeTimeout        time.Duration
+	commandInterval     time.Duration
+	queueLength         int
+	defaultAddresses    []int
+	modelAddresses      map[string][]int
+	controllerAddresses map[string][]int
+	aliases             map[string]string
+	rescanInterval      time.Duration
+	reconnectPolicy     ReconnectPolicy
+	rejected            map[string]bool
+	pending             map[string]bool
+	run                 *run // Current run, nil while the service is stopped
+	mu                  sync.RWMutex
+}
+
+// staticController is a manually configured serial port
+type staticController struct {
+	path string
+	mode *serial.Mode
 }
 
 // Option configures a Service
@@ -31,23 +56,145 @@ func WithLogger(logger *zap.SugaredLogger) Option {
 }
 
 // WithScanInterval sets the controller scan interval (default: 2s)
+// Set to 0 to scan only once when the service starts
 func WithScanInterval(interval time.Duration) Option {
 	return func(s *Service) {
 		s.scanInterval = interval
 	}
 }
 
+// WithSettleDelay sets how long to wait after opening a port before talking to the controller (default: 10s)
+func WithSettleDelay(delay time.Duration) Option {
+	return func(s *Service) {
+		s.settleDelay = delay
+	}
+}
+
+// WithProbeTimeout sets how long to wait for a port to answer the controller
+// handshake before it is rejected (default: 3s). Set to 0 to adopt ports without probing
+func WithProbeTimeout(timeout time.Duration) Option {
+	return func(s *Service) {
+		s.probeTimeout = timeout
+	}
+}
+
+// WithCommandInterval sets the pause between commands written to a controller (default: 250ms)
+// Set to 0 to write commands as soon as they are queued, without pacing
+func WithCommandInterval(interval time.Duration) Option {
+	return func(s *Service) {
+		s.commandInterval = interval
+	}
+}
+
+// WithQueueLength sets the maximum number of pending user commands per controller (default: 64)
+// Commands sent while the queue is full fail with ErrQueueFull. Set to 0 for no limit
+func WithQueueLength(length int) Option {
+	return func(s *Service) {
+		s.queueLength = length
+	}
+}
+
+// WithDeviceAddresses sets the X-Talk addresses probed for devices on every controller (default: 1-8)
+func WithDeviceAddresses(addresses ...int) Option {
+	return func(s *Service) {
+		s.defaultAddresses = normalizeAddresses(addresses)
+	}
+}
+
+// WithModelAddresses sets the X-Talk addresses probed on controllers of a given model, e.g. "XN-185"
+// The model is the product code reported by the controller handshake
+func WithModelAddresses(model string, addresses ...int) Option {
+	return func(s *Service) {
+		s.modelAddresses[model] = normalizeAddresses(addresses)
+	}
+}
+
+// WithControllerAddresses sets the X-Talk addresses probed on a specific controller
+// The controller is matched by alias, ID or port name
+func WithControllerAddresses(controllerName string, addresses ...int) Option {
+	return func(s *Service) {
+		s.controllerAddresses[controllerName] = normalizeAddresses(addresses)
+	}
+}
+
+// WithRescanInterval periodically re-enumerates devices on ready controllers (default: disabled)
+// Devices plugged in or removed are reported with "device"/"attached" and "device"/"detached" events
+func WithRescanInterval(interval time.Duration) Option {
+	return func(s *Service) {
+		s.rescanInterval = interval
+	}
+}
+
+// AddressRange returns the addresses from first to last inclusive, for use with WithDeviceAddresses
+func AddressRange(first int, last int) []int {
+	if last < first {
+		return nil
+	}
+
+	addresses := make([]int, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		addresses = append(addresses, i)
+	}
+	return addresses
+}
+
+// normalizeAddresses sorts addresses, dropping duplicates and any outside 1-999
+func normalizeAddresses(addresses []int) []int {
+	seen := make(map[int]bool, len(addresses))
+	result := make([]int, 0, len(addresses))
+	for _, a := range addresses {
+		if a < 1 || a > 999 || seen[a] {
+			continue
+		}
+		seen[a] = true
+		result = append(result, a)
+	}
+	sort.Ints(result)
+	return result
+}
+
+// WithStaticController opens a fixed serial port as a controller, e.g. /dev/ttyS0
+// or /dev/serial/by-id/..., regardless of its USB VID/PID. The port is reopened
+// whenever it is lost. If mode is nil the default of 115200 8N1 is used
+func WithStaticController(path string, mode *serial.Mode) Option {
+	return func(s *Service) {
+		s.staticControllers = append(s.staticControllers, staticController{
+			path: path,
+			mode: mode,
+		})
+	}
+}
+
+// WithAutoScan enables or disables USB auto-discovery (default: enabled)
+// Disable to use only controllers configured with WithStaticController
+func WithAutoScan(enabled bool) Option {
+	return func(s *Service) {
+		s.autoScan = enabled
+	}
+}
+
 // NewService creates a new Nexmosphere service
 func NewService(opts ...Option) *Service {
 	// Default logger
 	logger, _ := zap.NewDevelopment()
 
 	s := &Service{
-		controllers:  make(map[string]*Controller),
-		handlers:     make([]EventHandler, 0),
-		logger:       logger.Sugar(),
-		scanInterval: 2 * time.Second,
-		stopChan:     make(chan struct{}),
+		controllers:         make(map[string]*Controller),
+		handlers:            make([]*registeredHandler, 0),
+		logger:              logger.Sugar(),
+		autoScan:            true,
+		scanInterval:        2 * time.Second,
+		settleDelay:         10 * time.Second,
+		probeTimeout:        3 * time.Second,
+		commandInterval:     250 * time.Millisecond,
+		queueLength:         64,
+		defaultAddresses:    AddressRange(1, 8),
+		modelAddresses:      make(map[string][]int),
+		controllerAddresses: make(map[string][]int),
+		aliases:             make(map[string]string),
+		reconnectPolicy:     DefaultReconnectPolicy,
+		rejected:            make(map[string]bool),
+		pending:             make(map[string]bool),
 	}
 
 	// Apply options
@@ -59,73 +206,261 @@ func NewService(opts ...Option) *Service {
 }
 
 // Start begins scanning for controllers and dispatching events
+// The service runs until Stop is called
 func (s *Service) Start() error {
+	_, err := s.start(context.Background())
+	return err
+}
+
+// Run starts the service and blocks until ctx is cancelled or Stop is called, then stops it
+// Every goroutine started by the service has exited when Run returns
+func (s *Service) Run(ctx context.Context) error {
+	r, err := s.start(ctx)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return s.Stop()
+	case <-r.stopped:
+		return nil
+	}
+}
+
+// start launches the service goroutines, they all exit when the run's context is cancelled
+// If the service is still stopping, start waits for it to finish first
+func (s *Service) start(parent context.Context) (*run, error) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	for s.run != nil {
+		r := s.run
+		if !r.stopping {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("service already running")
+		}
 
-	if s.running {
-		return fmt.Errorf("service already running")
+		s.mu.Unlock()
+		<-r.stopped
+		s.mu.Lock()
 	}
+	defer s.mu.Unlock()
 
-	s.running = true
+	r := newRun(parent)
+	s.run = r
+	s.rejected = make(map[string]bool)
+	s.pending = make(map[string]bool)
 	s.logger.Info("Nexmosphere service starting")
 
-	// Initial scan
-	go s.scanForControllers()
+	// Open manually configured controllers
+	for _, sc := range s.staticControllers {
+		sc := sc
+		r.spawn(func() {
+			s.superviseController(r.ctx, sc.path, func() (Transport, error) {
+				return OpenSerial(sc.path, sc.mode)
+			})
+		})
+	}
 
-	// Start periodic scanning
-	s.scanTicker = time.NewTicker(s.scanInterval)
-	go func() {
-		for {
-			select {
-			case <-s.scanTicker.C:
-				s.scanForControllers()
-			case <-s.stopChan:
-				return
-			}
-		}
-	}()
+	// Connect to networked controllers
+	for _, addr := range s.tcpControllers {
+		s.superviseTCP(r, addr)
+	}
 
-	return nil
+	if s.autoScan {
+		r.spawn(func() { s.scanLoop(r.ctx) })
+	}
+
+	return r, nil
 }
 
-// Stop stops the service and closes all controllers
+// Stop stops the service, closes all controllers and waits for every goroutine to exit,
+// including handler calls, so a handler must not wait for it: use "go service.Stop()"
+// Handlers implementing io.Closer are then closed and unregistered
+// The service can be started again once Stop returns
 func (s *Service) Stop() error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	r := s.run
+	if r == nil {
+		s.mu.Unlock()
+		return nil
+	}
 
-	if !s.running {
+	// Already stopping, wait for it to finish
+	if r.stopping {
+		s.mu.Unlock()
+		<-r.stopped
 		return nil
 	}
 
 	s.logger.Info("Nexmosphere service stopping")
 
-	close(s.stopChan)
-
-	if s.scanTicker != nil {
-		s.scanTicker.Stop()
-	}
+	r.stopping = true
+	r.cancel()
 
-	// Close all controllers
+	// Close all controllers, their listeners clean up as they exit
 	for _, c := range s.controllers {
 		c.close()
 	}
+	s.mu.Unlock()
 
-	s.running = false
+	r.wait()
+
+	// Every event has been delivered, unregister the handlers that release resources so
+	// a closed handler isn't called again if the service is restarted
+	s.mu.Lock()
+	kept := make([]*registeredHandler, 0, len(s.handlers))
+	closers := make([]*registeredHandler, 0)
+	for _, h := range s.handlers {
+		if h.closer() {
+			closers = append(closers, h)
+		} else {
+			kept = append(kept, h)
+		}
+	}
+	s.handlers = kept
+	s.mu.Unlock()
+
+	for _, h := range closers {
+		h.remove()
+		h.close(s)
+	}
+
+	s.mu.Lock()
+	s.run = nil
+	s.mu.Unlock()
+	close(r.stopped)
+
+	s.logger.Info("Nexmosphere service stopped")
 	return nil
 }
 
-// AddHandler registers an event handler
-func (s *Service) AddHandler(h EventHandler) {
+// run is a single Start to Stop cycle of the service
+// Each run has its own context and goroutines, so a Start while the previous run
+// is stopping can't hold up that Stop
+type run struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	stopping   bool          // Set once Stop has begun, guarded by Service.mu
+	stopped    chan struct{} // Closed once Stop has finished
+	mu         sync.Mutex    // Guards goroutines
+	cond       *sync.Cond    // Broadcast when a goroutine exits
+	goroutines int
+}
+
+// newRun creates a run with a context derived from parent
+func newRun(parent context.Context) *run {
+	r := &run{stopped: make(chan struct{})}
+	r.ctx, r.cancel = context.WithCancel(parent)
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// spawn runs f in a goroutine tracked by the run
+func (r *run) spawn(f func()) {
+	r.mu.Lock()
+	r.goroutines++
+	r.mu.Unlock()
+
+	go func() {
+		defer func() {
+			r.mu.Lock()
+			r.goroutines--
+			r.cond.Broadcast()
+			r.mu.Unlock()
+		}()
+		f()
+	}()
+}
+
+// wait blocks until every goroutine of the run has exited
+// Unlike a WaitGroup, goroutines may still be spawned while it waits, e.g. to deliver events
+func (r *run) wait() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.goroutines > 0 {
+		r.cond.Wait()
+	}
+}
+
+// spawn runs f in a goroutine tracked by the current run
+// Must not be called with s.mu held
+func (s *Service) spawn(f func()) {
+	s.mu.RLock()
+	r := s.run
+	s.mu.RUnlock()
+
+	// Nothing waits for goroutines started while the service is stopped, such as
+	// deliveries of events dispatched after Stop
+	if r == nil {
+		go f()
+		return
+	}
+	r.spawn(f)
+}
+
+// scanLoop scans for USB controllers immediately and then periodically until ctx is cancelled
+func (s *Service) scanLoop(ctx context.Context) {
+	s.scanForControllers(ctx)
+
+	if s.scanInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.scanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.scanForControllers(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// AddHandler registers an event handler, the returned ID removes it with RemoveHandler
+// Handlers implementing io.Closer are closed and unregistered when the service stops
+func (s *Service) AddHandler(h EventHandler) HandlerID {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.handlers = append(s.handlers, h)
+
+	s.nextHandlerID++
+	s.handlers = append(s.handlers, newRegisteredHandler(s.nextHandlerID, h))
+	return s.nextHandlerID
 }
 
-// dispatch sends an event to all registered handlers
+// RemoveHandler unregisters an event handler, no further events are delivered to it
+// It waits for events the handler is already handling, so the handler isn't called once
+// RemoveHandler returns. A handler removing itself must not wait for it: use "go service.RemoveHandler(id)"
+// The handler isn't closed
+func (s *Service) RemoveHandler(id HandlerID) error {
+	s.mu.Lock()
+	var removed *registeredHandler
+	for i, h := range s.handlers {
+		if h.id == id {
+			// Copy so a dispatch in progress keeps its own view of the handlers
+			s.handlers = append(s.handlers[:i:i], s.handlers[i+1:]...)
+			removed = h
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if removed == nil {
+		return fmt.Errorf("handler %d not found", id)
+	}
+
+	removed.remove()
+	return nil
+}
+
+// dispatch sends an event to all registered handlers and subscribers
 func (s *Service) dispatch(event Event) {
 	s.mu.RLock()
 	handlers := s.handlers
+	subscriptions := s.subscriptions
 	s.mu.RUnlock()
 
 	event.Timestamp = time.Now()
@@ -134,53 +469,103 @@ func (s *Service) dispatch(event Event) {
 		event.Type, event.Action, event.Address, event.Controller)
 
 	for _, h := range handlers {
-		go h.HandleEvent(event) // Non-blocking dispatch
+		h.deliver(s, event)
+	}
+
+	for _, sub := range subscriptions {
+		if !sub.deliver(event) {
+			s.logger.Warnf("Subscriber fell behind, closing subscription")
+			s.unsubscribe(sub)
+		}
 	}
 }
 
 // GetControllers returns information about connected controllers
 func (s *Service) GetControllers() []ControllerInfo {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	info := make([]ControllerInfo, 0, len(s.controllers))
+	controllers := make([]*Controller, 0, len(s.controllers))
 	for _, c := range s.controllers {
+		controllers = append(controllers, c)
+	}
+	s.mu.RUnlock()
+
+	// Controller locks are taken without the service lock, which dispatch needs while holding them
+	info := make([]ControllerInfo, 0, len(controllers))
+	for _, c := range controllers {
 		info = append(info, c.getInfo())
 	}
 	return info
 }
 
-// SendCommand sends a command to a specific controller
+// SendCommand validates a raw command string, e.g. "X001A[5]", and queues it for a specific controller
 func (s *Service) SendCommand(controllerName string, cmd string) error {
-	s.mu.RLock()
-	c, ok := s.controllers[controllerName]
-	s.mu.RUnlock()
+	x, err := ParseCommand(cmd)
+	if err != nil {
+		return err
+	}
 
-	if !ok {
-		return fmt.Errorf("controller %s not found", controllerName)
+	return s.Send(controllerName, x)
+}
+
+// Send queues a typed command for a specific controller
+// Commands are written in order, paced by the command interval
+func (s *Service) Send(controllerName string, cmd Command) error {
+	c, err := s.getController(controllerName)
+	if err != nil {
+		return err
+	}
+
+	if err := c.addToQueue(commandQueue, cmd); err != nil {
+		return err
+	}
+
+	if sc, ok := cmd.(SettingCommand); ok {
+		c.saveSetting(sc)
+	}
+	return nil
+}
+
+// SendWait queues a typed command for a specific controller and waits until it has been written
+// If ctx ends before the command is written it is withdrawn from the queue and never sent
+func (s *Service) SendWait(ctx context.Context, controllerName string, cmd Command) error {
+	c, err := s.getController(controllerName)
+	if err != nil {
+		return err
 	}
 
-	return c.write(cmd)
+	done := make(chan error, 1)
+	if err := c.enqueue(commandQueue, cmd, done); err != nil {
+		return err
+	}
+
+	if err := c.waitForWrite(ctx, commandQueue, done); err != nil {
+		return err
+	}
+
+	// Only settings that were written are restored after a reconnect
+	if sc, ok := cmd.(SettingCommand); ok {
+		c.saveSetting(sc)
+	}
+	return nil
 }
 
 // SetDeviceHoldInterval configures the hold tick interval for a specific device
 // When set, "hold" events will be emitted periodically while a button is held
 // Set to 0 to disable hold events
 func (s *Service) SetDeviceHoldInterval(controllerName string, address int, interval time.Duration) error {
-	s.mu.RLock()
-	c, ok := s.controllers[controllerName]
-	s.mu.RUnlock()
-
-	if !ok {
-		return fmt.Errorf("controller %s not found", controllerName)
+	c, err := s.getController(controllerName)
+	if err != nil {
+		return err
 	}
 
 	if address < 1 || address >= 1000 {
 		return fmt.Errorf("invalid device address %d (must be 1-999)", address)
 	}
 
+	c.mu.Lock()
 	d := c.getDevice(address)
 	d.HoldTickInterval = interval
+	c.mu.Unlock()
 
 	s.logger.Debugf("Set hold interval for %s device %d to %s", controllerName, address, interval)
 	return nil
@@ -188,9 +573,17 @@ func (s *Service) SetDeviceHoldInterval(controllerName string, address int, inte
 
 // ControllerInfo provides information about a connected controller
 type ControllerInfo struct {
-	Name        string
-	IsUSB       bool
-	VID         string
-	PID         string
-	DeviceCount int
+	Name           string // Alias if configured, otherwise the ID
+	ID             string // Stable identity: "usb:<serial number>" for USB adapters that report one, otherwise the port name
+	Alias          string
+	Port           string // Port the controller is currently connected through, e.g. /dev/ttyUSB0
+	SerialNo       string // USB serial number, if reported
+	ProductCode    string // Model reported by the controller handshake, e.g. "XN-185"
+	Kind           string
+	IsUSB          bool
+	VID            string
+	PID            string
+	Connected      bool // False while reconnecting after the connection was lost
+	DeviceCount    int
+	RejectedFrames uint64 // Lines from the controller that failed to parse
 }
@@ -0,0 +1,189 @@
+package nexmosphere_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+	"github.org/carrierlabs/go-nexmosphere/simulator"
+	"go.uber.org/zap"
+)
+
+// newStoppedService returns a service that hasn't been started, without port scanning or settle delay
+func newStoppedService(opts ...nexmosphere.Option) *nexmosphere.Service {
+	opts = append([]nexmosphere.Option{
+		nexmosphere.WithLogger(zap.NewNop().Sugar()),
+		nexmosphere.WithAutoScan(false),
+		nexmosphere.WithSettleDelay(0),
+		nexmosphere.WithCommandInterval(time.Millisecond),
+		nexmosphere.WithDeviceAddresses(1),
+	}, opts...)
+	return nexmosphere.NewService(opts...)
+}
+
+// waitFor fails the test if done isn't closed within timeout
+func waitFor(t *testing.T, done <-chan struct{}, timeout time.Duration, what string) {
+	t.Helper()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+// TestStopFromHandler checks a handler can stop the service from a goroutine, which
+// Stop returns in once the handler call has finished
+func TestStopFromHandler(t *testing.T) {
+	for name, mode := range map[string]nexmosphere.DeliveryMode{"concurrent": nexmosphere.Concurrent, "ordered": nexmosphere.Ordered} {
+		mode := mode
+		t.Run(name, func(t *testing.T) {
+			s := newStoppedService(nexmosphere.WithDeliveryMode(mode))
+
+			var once sync.Once
+			stopped := make(chan struct{})
+			s.AddHandler(nexmosphere.EventHandlerFunc(func(e nexmosphere.Event) {
+				if e.Type == nexmosphere.TypeButton && e.Action == nexmosphere.ActionPress {
+					once.Do(func() {
+						go func() {
+							s.Stop()
+							close(stopped)
+						}()
+					})
+				}
+			}))
+
+			if err := s.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer s.Stop()
+
+			sim := simulator.New("sim", simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+			events, cancel := s.Subscribe(nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReady})
+			defer cancel()
+			if err := s.AddController(sim); err != nil {
+				t.Fatal(err)
+			}
+			waitForEvent(t, events, nexmosphere.Filter{}, 5*time.Second)
+			sim.PressButton(1, 1)
+			waitFor(t, stopped, 5*time.Second, "Stop called from a handler")
+
+			// A second Stop waits for the first to finish, the service can then start again
+			if err := s.Stop(); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Start(); err != nil {
+				t.Fatalf("Start after Stop from a handler: %s", err)
+			}
+		})
+	}
+}
+
+func TestStartDuringStop(t *testing.T) {
+	s := newStoppedService()
+
+	// The handler holds up Stop until released
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	s.AddHandler(nexmosphere.EventHandlerFunc(func(e nexmosphere.Event) {
+		if e.Type == nexmosphere.TypeButton && e.Action == nexmosphere.ActionPress {
+			once.Do(func() {
+				close(blocked)
+				<-release
+			})
+		}
+	}))
+
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	events, cancel := s.Subscribe(nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReady})
+	defer cancel()
+	sim := simulator.New("sim", simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+	if err := s.AddController(sim); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, nexmosphere.Filter{}, 5*time.Second)
+	sim.PressButton(1, 1)
+	waitFor(t, blocked, 5*time.Second, "handler call")
+
+	stopDone := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopDone)
+	}()
+
+	// Once Stop has begun the service reports it isn't running
+	deadline := time.Now().Add(5 * time.Second)
+	for !errors.Is(s.Rescan("none"), nexmosphere.ErrNotRunning) {
+		if time.Now().After(deadline) {
+			t.Fatal("Stop didn't begin")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Start waits for the stopping run rather than joining it
+	startDone := make(chan error, 1)
+	go func() { startDone <- s.Start() }()
+	time.Sleep(50 * time.Millisecond)
+
+	close(release)
+	waitFor(t, stopDone, 5*time.Second, "Stop")
+
+	select {
+	case err := <-startDone:
+		if err != nil {
+			t.Fatalf("Start during Stop: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Start")
+	}
+
+	// The new run is independent of the one that stopped
+	sim = simulator.New("sim", simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+	if err := s.AddController(sim); err != nil {
+		t.Fatalf("AddController after restart: %s", err)
+	}
+
+	stopDone = make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopDone)
+	}()
+	waitFor(t, stopDone, 5*time.Second, "Stop of the new run")
+}
+
+func TestRunReturnsOnStop(t *testing.T) {
+	s := newStoppedService()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	var runErr error
+	go func() {
+		runErr = s.Run(ctx)
+		close(done)
+	}()
+
+	// Wait for Run to start the service, Rescan reports ErrNotRunning until then
+	deadline := time.Now().Add(5 * time.Second)
+	for errors.Is(s.Rescan("none"), nexmosphere.ErrNotRunning) {
+		if time.Now().After(deadline) {
+			t.Fatal("Run didn't start the service")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, done, 5*time.Second, "Run to return after Stop")
+	if runErr != nil {
+		t.Errorf("Run() = %s", runErr)
+	}
+}
@@ -0,0 +1,161 @@
+package nexmosphere
+
+import (
+	"sync"
+)
+
+const defaultSubscriptionBuffer = 64
+
+// Filter selects the events delivered to a subscription
+// Empty fields match any event, so the zero Filter matches everything
+type Filter struct {
+	Type       EventType   // Event type, e.g. TypeButton
+	Action     EventAction // Event action, e.g. ActionPress
+	Controller string      // Controller name as in Event.Controller, the alias if one is set, see Subscribe
+	Address    int         // Device address, 0 matches any address
+	DeviceType string      // Type of the device the event came from, e.g. "XTB4N6"
+}
+
+// Match returns true if an event passes the filter
+func (f Filter) Match(event Event) bool {
+	switch {
+	case f.Type != "" && f.Type != event.Type:
+		return false
+	case f.Action != "" && f.Action != event.Action:
+		return false
+	case f.Controller != "" && f.Controller != event.Controller:
+		return false
+	case f.Address != 0 && f.Address != event.Address:
+		return false
+	case f.DeviceType != "" && f.DeviceType != event.DeviceType:
+		return false
+	}
+	return true
+}
+
+// OverflowPolicy decides what happens to events for a subscriber whose buffer is full
+type OverflowPolicy int
+
+const (
+	// DropNewest discards events that don't fit in the buffer
+	DropNewest OverflowPolicy = iota
+	// DropOldest discards the oldest buffered event to make room
+	DropOldest
+	// CloseOnOverflow cancels the subscription, closing its channel
+	CloseOnOverflow
+)
+
+// SubscribeOption configures a subscription
+type SubscribeOption func(*subscription)
+
+// WithBufferSize sets the number of events buffered for a subscriber (default: 64)
+func WithBufferSize(size int) SubscribeOption {
+	return func(sub *subscription) {
+		if size < 1 {
+			size = 1
+		}
+		sub.size = size
+	}
+}
+
+// WithOverflowPolicy sets what happens when a subscriber's buffer is full (default: DropNewest)
+func WithOverflowPolicy(policy OverflowPolicy) SubscribeOption {
+	return func(sub *subscription) {
+		sub.policy = policy
+	}
+}
+
+// subscription is a channel receiving the events that pass a filter
+type subscription struct {
+	filter Filter
+	size   int
+	policy OverflowPolicy
+	ch     chan Event
+	mu     sync.Mutex // Guards sending on ch and closed
+	closed bool
+}
+
+// Subscribe returns a channel receiving the events that match filter, in the order they were dispatched
+// Events are buffered and never block the service, see WithOverflowPolicy for what happens when
+// the subscriber falls behind. The channel is closed once cancel is called, cancel may be called
+// more than once. Subscriptions are kept when the service is stopped and started again
+// filter.Controller may also be the ID or port name of an aliased controller, it is replaced by the alias
+func (s *Service) Subscribe(filter Filter, opts ...SubscribeOption) (<-chan Event, func()) {
+	filter.Controller = s.eventName(filter.Controller)
+
+	sub := &subscription{
+		filter: filter,
+		size:   defaultSubscriptionBuffer,
+		policy: DropNewest,
+	}
+
+	for _, opt := range opts {
+		opt(sub)
+	}
+
+	sub.ch = make(chan Event, sub.size)
+
+	s.mu.Lock()
+	s.subscriptions = append(s.subscriptions, sub)
+	s.mu.Unlock()
+
+	return sub.ch, func() { s.unsubscribe(sub) }
+}
+
+// unsubscribe removes a subscription and closes its channel
+func (s *Service) unsubscribe(sub *subscription) {
+	s.mu.Lock()
+	for i, x := range s.subscriptions {
+		if x == sub {
+			s.subscriptions = append(s.subscriptions[:i:i], s.subscriptions[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	sub.close()
+}
+
+// deliver sends an event to the subscriber if it passes the filter
+// Returns false if the subscription overflowed and must be cancelled
+func (sub *subscription) deliver(event Event) bool {
+	if !sub.filter.Match(event) {
+		return true
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.closed {
+		return true
+	}
+
+	select {
+	case sub.ch <- event:
+		return true
+	default:
+	}
+
+	switch sub.policy {
+	case DropOldest:
+		select {
+		case <-sub.ch:
+		default:
+		}
+		sub.ch <- event
+	case CloseOnOverflow:
+		return false
+	}
+	return true
+}
+
+// close closes the subscription channel, no more events are delivered
+func (sub *subscription) close() {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if !sub.closed {
+		sub.closed = true
+		close(sub.ch)
+	}
+}
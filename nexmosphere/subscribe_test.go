@@ -0,0 +1,35 @@
+package nexmosphere_test
+
+import (
+	"testing"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+	"github.org/carrierlabs/go-nexmosphere/simulator"
+)
+
+// TestSubscribeAliasedController checks a subscription matches an aliased controller by alias or ID
+func TestSubscribeAliasedController(t *testing.T) {
+	s := newTestService(t,
+		nexmosphere.WithDeviceAddresses(1),
+		nexmosphere.WithControllerAlias("sim", "front-window"),
+	)
+
+	filter := nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReady}
+	byAlias, cancel := s.Subscribe(nexmosphere.Filter{Controller: "front-window", Type: filter.Type, Action: filter.Action})
+	defer cancel()
+	byID, cancel := s.Subscribe(nexmosphere.Filter{Controller: "sim", Type: filter.Type, Action: filter.Action})
+	defer cancel()
+
+	sim := simulator.New("sim", simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+	if err := s.AddController(sim); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, events := range map[string]<-chan nexmosphere.Event{"alias": byAlias, "ID": byID} {
+		e := waitForEvent(t, events, filter, 5*time.Second)
+		if e.Controller != "front-window" {
+			t.Errorf("by %s: Controller = %q, want the alias", name, e.Controller)
+		}
+	}
+}
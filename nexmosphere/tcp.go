@@ -0,0 +1,81 @@
+package nexmosphere
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const tcpDialTimeout = 5 * time.Second
+const tcpKeepAlive = 15 * time.Second
+
+// tcpTransport is a Transport backed by a raw TCP connection to a serial bridge
+type tcpTransport struct {
+	net.Conn
+	info TransportInfo
+}
+
+// Info returns the connection metadata
+func (t *tcpTransport) Info() TransportInfo {
+	return t.info
+}
+
+// DialTCP connects to a controller behind a raw serial-over-TCP bridge
+// such as ser2net or a Moxa NPort, addr is in host:port form
+func DialTCP(addr string) (Transport, error) {
+	dialer := net.Dialer{
+		Timeout:   tcpDialTimeout,
+		KeepAlive: tcpKeepAlive,
+	}
+
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tcpTransport{
+		Conn: conn,
+		info: TransportInfo{
+			Name: "tcp://" + addr,
+			Kind: "tcp",
+		},
+	}, nil
+}
+
+// AddTCPController registers a controller reachable at host:port
+// The connection is kept open in the background while the service is running
+// and re-established whenever it is lost. Each address can only be added once,
+// bridges often accept a single client and a second connection would compete with the first
+func (s *Service) AddTCPController(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.tcpControllers {
+		h, p, _ := net.SplitHostPort(existing)
+		if strings.EqualFold(h, host) && p == port {
+			return fmt.Errorf("TCP controller %s already added", addr)
+		}
+	}
+
+	s.tcpControllers = append(s.tcpControllers, addr)
+	if s.run != nil && !s.run.stopping {
+		s.superviseTCP(s.run, addr)
+	}
+	return nil
+}
+
+// superviseTCP starts a goroutine keeping a networked controller connected until the run stops
+// Called with s.mu held, so the goroutine is added to the run directly
+func (s *Service) superviseTCP(r *run, addr string) {
+	r.spawn(func() {
+		s.superviseController(r.ctx, "tcp://"+addr, func() (Transport, error) {
+			return DialTCP(addr)
+		})
+	})
+}
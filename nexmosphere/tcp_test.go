@@ -0,0 +1,156 @@
+package nexmosphere_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+	"github.org/carrierlabs/go-nexmosphere/simulator"
+)
+
+// newTCPBridge listens on a local port like a ser2net bridge, connecting each
+// accepted connection to a new simulator. The simulators are sent on the returned channel
+func newTCPBridge(t *testing.T, opts ...simulator.Option) (string, <-chan *simulator.Controller) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	sims := make(chan *simulator.Controller, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			sim := simulator.New("bridge", opts...)
+			sims <- sim
+			go func() { io.Copy(sim, conn); sim.Close() }()
+			go func() { io.Copy(conn, sim); conn.Close() }()
+		}
+	}()
+
+	return ln.Addr().String(), sims
+}
+
+// receiveSim returns the simulator behind the next bridge connection
+func receiveSim(t *testing.T, sims <-chan *simulator.Controller) *simulator.Controller {
+	t.Helper()
+
+	select {
+	case sim := <-sims:
+		return sim
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for a bridge connection")
+		return nil
+	}
+}
+
+func TestDialTCP(t *testing.T) {
+	addr, sims := newTCPBridge(t)
+
+	tr, err := nexmosphere.DialTCP(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	receiveSim(t, sims)
+
+	info := tr.Info()
+	if info.Name != "tcp://"+addr || info.Kind != "tcp" || info.IsUSB {
+		t.Errorf("Info() = %+v", info)
+	}
+
+	// Nothing listens on a closed listener's port
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed := ln.Addr().String()
+	ln.Close()
+	if _, err := nexmosphere.DialTCP(closed); err == nil {
+		t.Error("DialTCP to a closed port succeeded")
+	}
+}
+
+func TestAddTCPControllerInvalidAddress(t *testing.T) {
+	s := newTestService(t)
+	if err := s.AddTCPController("no-port"); err == nil {
+		t.Error("AddTCPController accepted an address without a port")
+	}
+}
+
+func TestAddTCPControllerDuplicate(t *testing.T) {
+	addr, sims := newTCPBridge(t, simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+	s := newTestService(t, nexmosphere.WithDeviceAddresses(1))
+
+	if err := s.AddTCPController(addr); err != nil {
+		t.Fatal(err)
+	}
+	receiveSim(t, sims)
+
+	_, port, _ := net.SplitHostPort(addr)
+	for _, dup := range []string{addr, "127.0.0.1:" + port} {
+		if err := s.AddTCPController(dup); err == nil {
+			t.Errorf("AddTCPController(%q) accepted an address already added", dup)
+		}
+	}
+
+	// No second connection is made to the bridge
+	select {
+	case <-sims:
+		t.Error("a duplicate address opened a second connection")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestTCPControllerReconnect(t *testing.T) {
+	addr, sims := newTCPBridge(t, simulator.WithDevice(1, "XTB4N6", "B4-0001"))
+	name := "tcp://" + addr
+
+	s := newTestService(t,
+		nexmosphere.WithDeviceAddresses(1),
+		nexmosphere.WithReconnectPolicy(nexmosphere.ReconnectPolicy{InitialDelay: 50 * time.Millisecond, MaxDelay: time.Second, Multiplier: 2}),
+	)
+	events, cancel := s.Subscribe(nexmosphere.Filter{Controller: name}, nexmosphere.WithBufferSize(256))
+	defer cancel()
+
+	if err := s.AddTCPController(addr); err != nil {
+		t.Fatal(err)
+	}
+	sim := receiveSim(t, sims)
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReady}, 5*time.Second)
+
+	controllers := s.GetControllers()
+	if len(controllers) != 1 || controllers[0].Name != name || controllers[0].Kind != "tcp" {
+		t.Fatalf("GetControllers() = %+v", controllers)
+	}
+
+	sim.PressButton(1, 2)
+	e := waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeButton, Action: nexmosphere.ActionPress}, 5*time.Second)
+	if e.Address != 1 || e.Data != "02" {
+		t.Errorf("press event = %+v", e)
+	}
+
+	// Drop the connection, the controller is reopened through the bridge
+	sim.Close()
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionDisconnected}, 5*time.Second)
+	sim = receiveSim(t, sims)
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReconnected}, 5*time.Second)
+
+	if !sim.WaitForCommand("D001B[TYPE]", 5*time.Second) {
+		t.Fatalf("devices not queried after reconnect, commands %v", sim.Commands())
+	}
+
+	sim.PressButton(1, 3)
+	e = waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeButton, Action: nexmosphere.ActionPress}, 5*time.Second)
+	if e.Address != 1 || e.Data != "03" {
+		t.Errorf("press event after reconnect = %+v", e)
+	}
+
+	if controllers := s.GetControllers(); len(controllers) != 1 {
+		t.Errorf("GetControllers() after reconnect = %+v", controllers)
+	}
+}
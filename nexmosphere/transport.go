@@ -0,0 +1,79 @@
+package nexmosphere
+
+import (
+	"io"
+
+	"go.bug.st/serial"
+	"go.bug.st/serial/enumerator"
+)
+
+// Transport is a byte stream connected to a Nexmosphere controller
+// Implementations exist for local serial ports, any other backend (TCP bridge,
+// pseudo-terminal, in-memory fake) can be attached with Service.AddController
+type Transport interface {
+	io.ReadWriteCloser
+
+	// Info returns metadata describing the connection
+	Info() TransportInfo
+}
+
+// TransportInfo describes the connection behind a Transport
+type TransportInfo struct {
+	Name         string // Unique connection name, e.g. the port path
+	Kind         string // Connection kind, e.g. "serial" or "tcp"
+	IsUSB        bool   // True when connected via a USB serial adapter
+	VID          string // USB vendor ID (USB only)
+	PID          string // USB product ID (USB only)
+	SerialNumber string // USB serial number (USB only, if reported)
+}
+
+// serialTransport is a Transport backed by a local serial port
+type serialTransport struct {
+	serial.Port
+	info TransportInfo
+}
+
+// Info returns the serial port metadata
+func (t *serialTransport) Info() TransportInfo {
+	return t.info
+}
+
+// defaultSerialMode returns the 115200 8N1 mode used by Nexmosphere controllers
+func defaultSerialMode() *serial.Mode {
+	return &serial.Mode{
+		BaudRate: 115200,
+		DataBits: 8,
+		Parity:   serial.NoParity,
+		StopBits: serial.OneStopBit,
+	}
+}
+
+// OpenSerial opens a serial port as a Transport
+// If mode is nil the Nexmosphere default of 115200 8N1 is used
+func OpenSerial(path string, mode *serial.Mode) (Transport, error) {
+	return openSerialTransport(&enumerator.PortDetails{Name: path}, mode)
+}
+
+// openSerialTransport opens an enumerated serial port as a Transport
+func openSerialTransport(port *enumerator.PortDetails, mode *serial.Mode) (Transport, error) {
+	if mode == nil {
+		mode = defaultSerialMode()
+	}
+
+	p, err := serial.Open(port.Name, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &serialTransport{
+		Port: p,
+		info: TransportInfo{
+			Name:         port.Name,
+			Kind:         "serial",
+			IsUSB:        port.IsUSB,
+			VID:          port.VID,
+			PID:          port.PID,
+			SerialNumber: port.SerialNumber,
+		},
+	}, nil
+}
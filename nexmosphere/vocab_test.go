@@ -0,0 +1,170 @@
+package nexmosphere_test
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+	"github.org/carrierlabs/go-nexmosphere/simulator"
+)
+
+// silentTransport accepts writes and never answers, like a port without a controller
+type silentTransport struct {
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newSilentTransport() *silentTransport {
+	return &silentTransport{closed: make(chan struct{})}
+}
+
+func (t *silentTransport) Read(p []byte) (int, error) {
+	<-t.closed
+	return 0, io.EOF
+}
+
+func (t *silentTransport) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (t *silentTransport) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+func (t *silentTransport) Info() nexmosphere.TransportInfo {
+	return nexmosphere.TransportInfo{Name: "silent", Kind: "test"}
+}
+
+// eventRecorder records the type/action pairs of dispatched events
+type eventRecorder struct {
+	mu   sync.Mutex
+	seen map[nexmosphere.EventType]map[nexmosphere.EventAction]bool
+}
+
+func (r *eventRecorder) HandleEvent(e nexmosphere.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.seen[e.Type] == nil {
+		r.seen[e.Type] = make(map[nexmosphere.EventAction]bool)
+	}
+	r.seen[e.Type][e.Action] = true
+}
+
+func (r *eventRecorder) has(eventType nexmosphere.EventType, action nexmosphere.EventAction) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seen[eventType][action]
+}
+
+// TestVocabulary drives every path that emits events and checks the emitted
+// type/action pairs are exactly those listed by Vocabulary
+func TestVocabulary(t *testing.T) {
+	recorder := &eventRecorder{seen: make(map[nexmosphere.EventType]map[nexmosphere.EventAction]bool)}
+
+	s := newTestService(t,
+		nexmosphere.WithDeviceAddresses(1, 2, 3),
+		nexmosphere.WithProbeTimeout(200*time.Millisecond),
+		nexmosphere.WithReconnectPolicy(nexmosphere.ReconnectPolicy{InitialDelay: 50 * time.Millisecond, MaxDelay: time.Second, Multiplier: 2}),
+	)
+	s.AddHandler(recorder)
+	events, cancel := s.Subscribe(nexmosphere.Filter{}, nexmosphere.WithBufferSize(1024))
+	defer cancel()
+
+	// Controller: system-update and ready
+	sim := simulator.New("sim",
+		simulator.WithDevice(1, "XTB4N6", "B4-0001"),
+		simulator.WithDevice(2, "XRDR1", "RD-0001"),
+		simulator.WithDevice(3, "XY240", "XY-0001"),
+	)
+	if err := s.AddController(sim); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReady}, 5*time.Second)
+
+	// Antenna status is requested when the RFID reader is discovered
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeRFIDAntenna, Action: nexmosphere.ActionStatus}, 5*time.Second)
+
+	// Buttons: closed, press, chord, hold, open and release
+	if err := s.SetDeviceHoldInterval("sim", 1, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	sim.SetButtons(1, 0x3)
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeButton, Action: nexmosphere.ActionHold}, 5*time.Second)
+	sim.SetButtons(1, 0)
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeButton, Action: nexmosphere.ActionRelease}, 5*time.Second)
+
+	// RFID: pickup, putback and unknown tag feedback
+	sim.Putback(2, 7)
+	sim.Pickup(2, 7)
+	sim.Emit("XR[XX007]")
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeRFIDTag, Action: nexmosphere.ActionUnknown}, 5*time.Second)
+
+	// Presence
+	sim.SetZone(3, 2)
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypePresence}, 5*time.Second)
+
+	// Controller: parse-error
+	sim.Emit("garbage")
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionParseError}, 5*time.Second)
+
+	// Device: detached and attached, by swapping a device
+	sim.AddDevice(3, "XTB4N6", "B4-0002")
+	if err := s.Rescan("sim"); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeDevice, Action: nexmosphere.ActionAttached}, 5*time.Second)
+
+	// Controller: rejected
+	if err := s.AddController(newSilentTransport()); err == nil {
+		t.Fatal("silent transport was adopted")
+	}
+
+	// Controller: disconnected and reconnected
+	addr, sims := newTCPBridge(t,
+		simulator.WithDevice(1, "XTB4N6", "B4-0004"),
+		simulator.WithDevice(2, "XTB4N6", "B4-0005"),
+		simulator.WithDevice(3, "XTB4N6", "B4-0006"),
+	)
+	if err := s.AddTCPController(addr); err != nil {
+		t.Fatal(err)
+	}
+	bridged := receiveSim(t, sims)
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReady, Controller: "tcp://" + addr}, 5*time.Second)
+	bridged.Close()
+	receiveSim(t, sims)
+	waitForEvent(t, events, nexmosphere.Filter{Type: nexmosphere.TypeController, Action: nexmosphere.ActionReconnected}, 5*time.Second)
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything emitted is in the vocabulary
+	vocabulary := nexmosphere.Vocabulary()
+	listed := make(map[nexmosphere.EventType]map[nexmosphere.EventAction]bool)
+	for eventType, actions := range vocabulary {
+		listed[eventType] = make(map[nexmosphere.EventAction]bool)
+		for _, action := range actions {
+			listed[eventType][action] = true
+		}
+	}
+	for eventType, actions := range recorder.seen {
+		for action := range actions {
+			if !listed[eventType][action] {
+				t.Errorf("emitted %s/%s is missing from Vocabulary", eventType, action)
+			}
+		}
+	}
+
+	// Everything in the vocabulary was emitted
+	for eventType, actions := range vocabulary {
+		for _, action := range actions {
+			if !recorder.has(eventType, action) {
+				t.Errorf("%s/%s is listed in Vocabulary but was never emitted", eventType, action)
+			}
+		}
+	}
+}
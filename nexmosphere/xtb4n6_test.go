@@ -0,0 +1,181 @@
+package nexmosphere
+
+import (
+	"fmt"
+	"testing"
+)
+
+// decodeXTB4N6 feeds a frame to the XTB4N6 driver
+func decodeXTB4N6(d *Device, format string, command string) []Event {
+	return xtb4n6Driver{}.Decode(d, Frame{Type: "X", Address: 1, Format: format, Command: command}, nil)
+}
+
+func TestXTB4N6Decode(t *testing.T) {
+	tests := []struct {
+		state       int
+		held        []int  // Buttons closed
+		ledFeedback bool   // Bit 0
+		chord       string // Data of the chord event, empty if none
+	}{
+		{0, []int{}, false, ""},
+		{1, []int{}, true, ""},
+		{2, []int{1}, false, ""},
+		{3, []int{1}, true, ""},
+		{4, []int{2}, false, ""},
+		{5, []int{2}, true, ""},
+		{6, []int{1, 2}, false, "01+02"},
+		{7, []int{1, 2}, true, "01+02"},
+		{8, []int{3}, false, ""},
+		{9, []int{3}, true, ""},
+		{10, []int{1, 3}, false, "01+03"},
+		{11, []int{1, 3}, true, "01+03"},
+		{12, []int{2, 3}, false, "02+03"},
+		{13, []int{2, 3}, true, "02+03"},
+		{14, []int{1, 2, 3}, false, "01+02+03"},
+		{15, []int{1, 2, 3}, true, "01+02+03"},
+		{16, []int{4}, false, ""},
+		{17, []int{4}, true, ""},
+		{18, []int{1, 4}, false, "01+04"},
+		{19, []int{1, 4}, true, "01+04"},
+		{20, []int{2, 4}, false, "02+04"},
+		{21, []int{2, 4}, true, "02+04"},
+		{22, []int{1, 2, 4}, false, "01+02+04"},
+		{23, []int{1, 2, 4}, true, "01+02+04"},
+		{24, []int{3, 4}, false, "03+04"},
+		{25, []int{3, 4}, true, "03+04"},
+		{26, []int{1, 3, 4}, false, "01+03+04"},
+		{27, []int{1, 3, 4}, true, "01+03+04"},
+		{28, []int{2, 3, 4}, false, "02+03+04"},
+		{29, []int{2, 3, 4}, true, "02+03+04"},
+		{30, []int{1, 2, 3, 4}, false, "01+02+03+04"},
+		{31, []int{1, 2, 3, 4}, true, "01+02+03+04"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.state), func(t *testing.T) {
+			d := &Device{Type: "XTB4N6"}
+			events := decodeXTB4N6(d, "A", fmt.Sprint(tt.state))
+
+			held := make(map[int]bool)
+			for _, b := range tt.held {
+				held[b] = true
+			}
+			for b := 1; b <= buttonCount; b++ {
+				if d.Button[b-1].Closed != held[b] || d.Button[b-1].Pressed != held[b] {
+					t.Errorf("button %d closed=%v pressed=%v, want %v", b, d.Button[b-1].Closed, d.Button[b-1].Pressed, held[b])
+				}
+			}
+			if d.LEDFeedback != tt.ledFeedback {
+				t.Errorf("LEDFeedback = %v, want %v", d.LEDFeedback, tt.ledFeedback)
+			}
+
+			// A closed and a press event per held button, then the chord
+			var want []Event
+			for _, b := range tt.held {
+				data := fmt.Sprintf("%02d", b)
+				want = append(want,
+					Event{Type: TypeButton, Action: ActionClosed, Data: data},
+					Event{Type: TypeButton, Action: ActionPress, Data: data},
+				)
+			}
+			if tt.chord != "" {
+				want = append(want, Event{Type: TypeButton, Action: ActionChord, Data: tt.chord})
+			}
+			if len(events) != len(want) {
+				t.Fatalf("got %d events %+v, want %d", len(events), events, len(want))
+			}
+			for i := range want {
+				if events[i].Type != want[i].Type || events[i].Action != want[i].Action || events[i].Data != want[i].Data {
+					t.Errorf("event %d = %+v, want %+v", i, events[i], want[i])
+				}
+			}
+
+			// Releasing every button opens and releases each held button, without a chord
+			events = decodeXTB4N6(d, "A", "0")
+			if len(events) != 2*len(tt.held) {
+				t.Fatalf("release got %d events %+v, want %d", len(events), events, 2*len(tt.held))
+			}
+			for _, e := range events {
+				if e.Action != ActionOpen && e.Action != ActionRelease {
+					t.Errorf("release event %+v", e)
+				}
+			}
+			if d.LEDFeedback {
+				t.Error("LEDFeedback still set")
+			}
+		})
+	}
+}
+
+func TestXTB4N6DecodeChordAddsButton(t *testing.T) {
+	d := &Device{Type: "XTB4N6"}
+	decodeXTB4N6(d, "A", "3") // Button 1
+
+	events := decodeXTB4N6(d, "A", "11") // Buttons 1 and 3
+	if len(events) != 3 || events[1].Action != ActionPress || events[1].Data != "03" || events[2].Action != ActionChord || events[2].Data != "01+03" {
+		t.Fatalf("events = %+v", events)
+	}
+
+	payload, ok := events[2].Button()
+	if !ok || payload.Button != 0 || len(payload.Buttons) != 2 || payload.Buttons[0] != 1 || payload.Buttons[1] != 3 {
+		t.Errorf("Button() = %+v, %v", payload, ok)
+	}
+
+	// Releasing one of three held buttons isn't a new chord
+	decodeXTB4N6(d, "A", "15") // Buttons 1, 2 and 3
+	events = decodeXTB4N6(d, "A", "7")
+	for _, e := range events {
+		if e.Action == ActionChord {
+			t.Errorf("chord on release: %+v", events)
+		}
+	}
+}
+
+func TestXTB4N6DecodeRejected(t *testing.T) {
+	for _, command := range []string{"32", "33", "255", "-1", "x", "", "1.5", "0x3", " 3"} {
+		d := &Device{Type: "XTB4N6"}
+		decodeXTB4N6(d, "A", "5")
+
+		if events := decodeXTB4N6(d, "A", command); events != nil {
+			t.Errorf("state %q returned events %+v", command, events)
+		}
+		if !d.Button[1].Closed || !d.LEDFeedback {
+			t.Errorf("state %q changed the device", command)
+		}
+	}
+}
+
+func TestXTB4N6DecodeLED(t *testing.T) {
+	d := &Device{Type: "XTB4N6"}
+
+	tests := []struct {
+		command string
+		button  int
+		want    LEDMode
+	}{
+		{"LED1=ON", 1, LEDOn},
+		{"LED2=blink", 2, LEDBlink},
+		{"LED4=PULSE", 4, LEDPulse},
+		{"LED1=OFF", 1, LEDOff},
+	}
+	for _, tt := range tests {
+		if events := decodeXTB4N6(d, "B", tt.command); events != nil {
+			t.Errorf("%q returned events %+v", tt.command, events)
+		}
+		if d.Button[tt.button-1].LED != tt.want {
+			t.Errorf("%q: LED %d = %s, want %s", tt.command, tt.button, d.Button[tt.button-1].LED, tt.want)
+		}
+	}
+
+	// Malformed LED feedback and other formats are ignored
+	for _, command := range []string{"LED5=ON", "LED0=ON", "LED2=DISCO", "LED2", "LEDx=ON", "FOO=1"} {
+		before := d.Button
+		decodeXTB4N6(d, "B", command)
+		if d.Button != before {
+			t.Errorf("%q changed the buttons", command)
+		}
+	}
+	if events := decodeXTB4N6(d, "C", "3"); events != nil {
+		t.Errorf("format C returned events %+v", events)
+	}
+}
@@ -0,0 +1,425 @@
+// Package simulator provides an in-process virtual Nexmosphere controller
+//
+// A simulated Controller emulates an XN-185 on a fake port: it implements
+// nexmosphere.Transport so it can be attached with Service.AddController,
+// answers diagnostic queries for its configured devices, records every
+// command written by the library and lets tests or demos script device
+// feedback such as button presses, RFID pickups and presence zone changes.
+package simulator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.org/carrierlabs/go-nexmosphere/nexmosphere"
+)
+
+// DefaultModel is the controller model reported to diagnostic queries
+const DefaultModel = "XN-185"
+
+// commandPattern matches a command written by the library, e.g. D001B[TYPE]
+var commandPattern = regexp.MustCompile(`^([A-Z])(\d{3})([A-Z])\[(.*)\]$`)
+
+// Device is a simulated X-Talk device
+type Device struct {
+	Type   string
+	Serial string
+
+	buttons int          // XTB4N6 button bitmask (bit 0 = button 1)
+	leds    [4]string    // XTB4N6 LED modes set by the library, e.g. "BLINK"
+	tags    map[int]bool // XRDR1 tags currently on the antenna
+	zone    int          // XY240 detection zone
+}
+
+// Controller is a virtual Nexmosphere controller
+type Controller struct {
+	name    string
+	model   string
+	devices map[int]*Device
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	rbuf     bytes.Buffer // Feedback waiting to be read by the library
+	wbuf     bytes.Buffer // Partial command written by the library
+	commands []string
+	closed   bool
+}
+
+// Option configures a simulated Controller
+type Option func(*Controller)
+
+// WithModel sets the controller model reported to diagnostic queries (default: XN-185)
+func WithModel(model string) Option {
+	return func(c *Controller) {
+		c.model = model
+	}
+}
+
+// WithDevice connects a device to an X-Talk address
+func WithDevice(address int, deviceType string, serial string) Option {
+	return func(c *Controller) {
+		c.devices[address] = newDevice(deviceType, serial)
+	}
+}
+
+// New creates a simulated controller, name is reported as the transport name
+func New(name string, opts ...Option) *Controller {
+	c := &Controller{
+		name:    name,
+		model:   DefaultModel,
+		devices: make(map[int]*Device),
+	}
+	c.cond = sync.NewCond(&c.mu)
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// newDevice creates a device of a given type
+func newDevice(deviceType string, serial string) *Device {
+	return &Device{
+		Type:   deviceType,
+		Serial: serial,
+		tags:   make(map[int]bool),
+	}
+}
+
+// Info implements nexmosphere.Transport
+func (c *Controller) Info() nexmosphere.TransportInfo {
+	return nexmosphere.TransportInfo{
+		Name: c.name,
+		Kind: "simulator",
+	}
+}
+
+// Read implements io.Reader, blocking until feedback is available or the controller is closed
+func (c *Controller) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.rbuf.Len() == 0 && !c.closed {
+		c.cond.Wait()
+	}
+
+	if c.rbuf.Len() == 0 {
+		return 0, io.EOF
+	}
+
+	return c.rbuf.Read(p)
+}
+
+// Write implements io.Writer, recording and answering every complete command
+func (c *Controller) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	c.wbuf.Write(p)
+
+	for {
+		line, err := c.wbuf.ReadString('\n')
+		if err != nil {
+			// Keep incomplete command for the next write
+			c.wbuf.Reset()
+			c.wbuf.WriteString(line)
+			break
+		}
+
+		cmd := strings.TrimRight(line, "\r\n")
+		if cmd == "" {
+			continue
+		}
+
+		c.commands = append(c.commands, cmd)
+		c.respond(cmd)
+	}
+
+	c.cond.Broadcast()
+	return len(p), nil
+}
+
+// Close implements io.Closer, pending reads return io.EOF
+func (c *Controller) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	c.cond.Broadcast()
+	return nil
+}
+
+// respond answers a command written by the library, must be called with lock held
+func (c *Controller) respond(cmd string) {
+	m := commandPattern.FindStringSubmatch(cmd)
+	if m == nil {
+		return
+	}
+
+	address, _ := strconv.Atoi(m[2])
+	payload := m[4]
+
+	switch m[1] + m[3] {
+	case "DB": // Diagnostic query
+		if address == 0 {
+			if payload == "TYPE" {
+				c.emit(fmt.Sprintf("D000B[TYPE=%s]", c.model))
+			}
+			return
+		}
+
+		d, ok := c.devices[address]
+		if !ok {
+			return
+		}
+
+		switch payload {
+		case "TYPE":
+			c.emit(fmt.Sprintf("D%03dB[TYPE=%s]", address, d.Type))
+		case "SERIAL":
+			c.emit(fmt.Sprintf("D%03dB[SERIAL=%s]", address, d.Serial))
+		}
+
+	case "XB": // X-Talk status request or device command
+		d, ok := c.devices[address]
+		if !ok {
+			return
+		}
+
+		switch {
+		case d.Type == "XRDR1" && payload == "":
+			c.emit(fmt.Sprintf("X%03dB[%s]", address, d.antennaStatus()))
+		case d.Type == "XTB4N6" && strings.HasPrefix(payload, "LED"):
+			// LED command, e.g. LED2=BLINK
+			key, mode, ok := strings.Cut(strings.TrimPrefix(payload, "LED"), "=")
+			button, err := strconv.Atoi(key)
+			if ok && err == nil && button >= 1 && button <= len(d.leds) {
+				d.leds[button-1] = mode
+			}
+		}
+	}
+}
+
+// ButtonLED returns the LED mode last set on an XTB4N6 button (1-4), e.g. "BLINK", or "" if never set
+func (c *Controller) ButtonLED(address int, button int) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if button < 1 || button > 4 {
+		return "", fmt.Errorf("invalid button %d (must be 1-4)", button)
+	}
+
+	d, err := c.device(address, "XTB4N6")
+	if err != nil {
+		return "", err
+	}
+	return d.leds[button-1], nil
+}
+
+// antennaStatus returns the tags on an RFID antenna in status format, e.g. "d003 d005"
+func (d *Device) antennaStatus() string {
+	tags := make([]int, 0, len(d.tags))
+	for tag := range d.tags {
+		tags = append(tags, tag)
+	}
+	sort.Ints(tags)
+
+	parts := make([]string, len(tags))
+	for i, tag := range tags {
+		parts[i] = fmt.Sprintf("d%03d", tag)
+	}
+	return strings.Join(parts, " ")
+}
+
+// emit queues a feedback line for the library, must be called with lock held
+func (c *Controller) emit(line string) {
+	c.rbuf.WriteString(line)
+	c.rbuf.WriteString("\r\n")
+	c.cond.Broadcast()
+}
+
+// Emit sends a raw feedback line to the library, e.g. to inject malformed input
+func (c *Controller) Emit(line string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+
+	c.emit(line)
+	return nil
+}
+
+// device returns a device of the expected type, must be called with lock held
+func (c *Controller) device(address int, deviceType string) (*Device, error) {
+	if c.closed {
+		return nil, io.ErrClosedPipe
+	}
+
+	d, ok := c.devices[address]
+	if !ok {
+		return nil, fmt.Errorf("no device at address %d", address)
+	}
+	if d.Type != deviceType {
+		return nil, fmt.Errorf("device at address %d is %s, not %s", address, d.Type, deviceType)
+	}
+	return d, nil
+}
+
+// AddDevice connects a device to an X-Talk address, replacing any existing device
+func (c *Controller) AddDevice(address int, deviceType string, serial string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.devices[address] = newDevice(deviceType, serial)
+}
+
+// RemoveDevice disconnects the device at an X-Talk address
+func (c *Controller) RemoveDevice(address int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.devices, address)
+}
+
+// SetButtons sets the state of all XTB4N6 buttons as a bitmask (bit 0 = button 1)
+func (c *Controller) SetButtons(address int, mask int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d, err := c.device(address, "XTB4N6")
+	if err != nil {
+		return err
+	}
+
+	d.buttons = mask & 0x0f
+
+	// Buttons are reported in bits 1-4, bit 0 is the LED feedback flag
+	c.emit(fmt.Sprintf("X%03dA[%d]", address, d.buttons<<1|1))
+	return nil
+}
+
+// PressButton closes an XTB4N6 button (1-4)
+func (c *Controller) PressButton(address int, button int) error {
+	return c.updateButton(address, button, true)
+}
+
+// ReleaseButton opens an XTB4N6 button (1-4)
+func (c *Controller) ReleaseButton(address int, button int) error {
+	return c.updateButton(address, button, false)
+}
+
+// updateButton changes the state of a single XTB4N6 button
+func (c *Controller) updateButton(address int, button int, closed bool) error {
+	if button < 1 || button > 4 {
+		return fmt.Errorf("invalid button %d (must be 1-4)", button)
+	}
+
+	c.mu.Lock()
+	d, err := c.device(address, "XTB4N6")
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	mask := d.buttons
+	c.mu.Unlock()
+
+	bit := 1 << (button - 1)
+	if closed {
+		mask |= bit
+	} else {
+		mask &^= bit
+	}
+
+	return c.SetButtons(address, mask)
+}
+
+// Pickup lifts an RFID tag from an XRDR1 antenna
+func (c *Controller) Pickup(address int, tag int) error {
+	return c.updateTag(address, tag, false)
+}
+
+// Putback places an RFID tag on an XRDR1 antenna
+func (c *Controller) Putback(address int, tag int) error {
+	return c.updateTag(address, tag, true)
+}
+
+// updateTag emits the XR tag and X-Talk antenna feedback for a pickup or putback
+func (c *Controller) updateTag(address int, tag int, present bool) error {
+	if tag < 1 || tag > 999 {
+		return fmt.Errorf("invalid tag %d (must be 1-999)", tag)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d, err := c.device(address, "XRDR1")
+	if err != nil {
+		return err
+	}
+
+	if present {
+		d.tags[tag] = true
+		c.emit(fmt.Sprintf("XR[PB%03d]", tag))
+		c.emit(fmt.Sprintf("X%03dA[0]", address))
+	} else {
+		delete(d.tags, tag)
+		c.emit(fmt.Sprintf("XR[PU%03d]", tag))
+		c.emit(fmt.Sprintf("X%03dA[1]", address))
+	}
+	return nil
+}
+
+// SetZone reports a new detection zone from an XY240 presence sensor
+func (c *Controller) SetZone(address int, zone int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d, err := c.device(address, "XY240")
+	if err != nil {
+		return err
+	}
+
+	d.zone = zone
+	c.emit(fmt.Sprintf("X%03dB[Dz=%02d]", address, zone))
+	return nil
+}
+
+// Commands returns every command written by the library, in order
+func (c *Controller) Commands() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cmds := make([]string, len(c.commands))
+	copy(cmds, c.commands)
+	return cmds
+}
+
+// WaitForCommand blocks until the library has written a command or the timeout expires
+func (c *Controller) WaitForCommand(cmd string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		for _, x := range c.Commands() {
+			if x == cmd {
+				return true
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}